@@ -1,6 +1,6 @@
 module cybersecbot
 
-go 1.20
+go 1.21
 
 require github.com/bwmarrin/discordgo v0.27.1
 
@@ -1,13 +0,0 @@
-#!/bin/bash
-
-# cp rss_tests/hackernews/oldXML.xml rss_tests/hackernews/xmlfeed.xml
-# cp rss_tests/project_zero/oldxmlfeed.xml rss_tests/project_zero/newgooglefeed.xml
-# cp rss_tests/zdi/oldfeed.xml rss_tests/zdi/feed.xml
-cp rss_tests/portswigger/oldfeed.xml rss_tests/portswigger/feed.xml
-
-sleep 10
-
-# cp rss_tests/hackernews/newXML.xml rss_tests/hackernews/xmlfeed.xml
-# cp rss_tests/project_zero/googlefeed.xml rss_tests/project_zero/newgooglefeed.xml
-# cp rss_tests/zdi/newfeed.xml rss_tests/zdi/feed.xml
-cp rss_tests/portswigger/newfeed.xml rss_tests/portswigger/feed.xml
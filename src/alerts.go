@@ -0,0 +1,103 @@
+/*
+Alerts posted to the admin channel when a feed breaks: its poll loop stops after an error it can't recover from, its
+polls keep failing, or it hasn't had a new item for longer than the staleness threshold. Each problem is announced
+once, followed by a notice when it clears up.
+*/
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+const defaultStaleAfterDays = 14
+
+// feeds without a new item for this long are reported as stale, zero turns the check off
+var staleAfter = defaultStaleAfterDays * 24 * time.Hour
+
+// feedAlertState is which problems we've already told the admins about for a feed
+type feedAlertState struct {
+	stopped bool
+	failing bool
+	stale   bool
+}
+
+var (
+	feedAlertsMu sync.Mutex
+	// keyed by URL rather than kept on the monitor, so a restarted monitor can report that the feed has recovered
+	feedAlerts = map[string]*feedAlertState{}
+)
+
+func sendAdminAlert(text string) {
+	slog.Warn("feed alert", "alert", text)
+	if adminChannelId == "" || discord == nil {
+		return
+	}
+	if _, err := discord.sendMessage(adminChannelId, &discordgo.MessageSend{Content: text}); err != nil {
+		slog.Error("posting alert to admin channel", "channel", adminChannelId, "err", err)
+	}
+}
+
+func checkFeedAlerts(monitor *feedMonitor) {
+	/*
+		Compare the monitor's state against what has already been reported and send any new alerts or recovery
+		notices. Called whenever a poll finishes or a loop stops.
+	*/
+	feedStatus := monitor.snapshot()
+	var alerts []string
+
+	feedAlertsMu.Lock()
+	state, ok := feedAlerts[feedStatus.URL]
+	if !ok {
+		state = &feedAlertState{}
+		feedAlerts[feedStatus.URL] = state
+	}
+
+	lastErr := "unknown error"
+	if feedStatus.LastErr != nil {
+		lastErr = truncate(feedStatus.LastErr.Error(), 500)
+	}
+
+	if !feedStatus.Running && !state.stopped {
+		state.stopped = true
+		alerts = append(alerts, fmt.Sprintf(":rotating_light: Stopped polling <%s> after an error it can't recover from: `%s`\n"+
+			"Fix the feed or its parser, then use `/feed resume` to start it again.", feedStatus.URL, lastErr))
+	} else if feedStatus.Running && state.stopped && feedStatus.LastErr == nil && !feedStatus.LastSuccess.IsZero() {
+		state.stopped = false
+		alerts = append(alerts, fmt.Sprintf(":white_check_mark: <%s> is being polled again", feedStatus.URL))
+	}
+
+	if feedStatus.Failures >= feedFailureThreshold && !state.failing {
+		state.failing = true
+		alerts = append(alerts, fmt.Sprintf(":warning: The last %d polls of <%s> failed: `%s`",
+			feedStatus.Failures, feedStatus.URL, lastErr))
+	} else if feedStatus.Failures == 0 && state.failing {
+		state.failing = false
+		alerts = append(alerts, fmt.Sprintf(":white_check_mark: <%s> is responding again", feedStatus.URL))
+	}
+
+	isStale := staleAfter > 0 && !feedStatus.Paused && time.Since(feedStatus.LastNewItem) > staleAfter
+	if isStale && !state.stale {
+		state.stale = true
+		alerts = append(alerts, fmt.Sprintf(":hourglass: <%s> hasn't had a new item since <t:%d:f>, has its URL changed?",
+			feedStatus.URL, feedStatus.LastNewItem.Unix()))
+	} else if !isStale && state.stale && !feedStatus.Paused {
+		state.stale = false
+		alerts = append(alerts, fmt.Sprintf(":white_check_mark: <%s> has new items again", feedStatus.URL))
+	}
+	feedAlertsMu.Unlock()
+
+	for _, alert := range alerts {
+		sendAdminAlert(alert)
+	}
+}
+
+func clearFeedAlerts(url string) {
+	feedAlertsMu.Lock()
+	defer feedAlertsMu.Unlock()
+	delete(feedAlerts, url)
+}
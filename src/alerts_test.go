@@ -0,0 +1,107 @@
+package main
+
+import (
+	"errors"
+	"log/slog"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testMonitor is a running monitor that has just had a new item, outside any scheduler
+func testMonitor(t *testing.T) *feedMonitor {
+	t.Helper()
+	t.Cleanup(func() { clearFeedAlerts(testFeedUrl) })
+	return &feedMonitor{url: testFeedUrl, feedType: "zdi", log: slog.Default(), running: true, lastNewItem: time.Now()}
+}
+
+// alertsSince returns the admin channel messages after the first skip, for checking what a step posted
+func alertsSince(fake *recordingClient, skip int) []string {
+	var alerts []string
+	for _, message := range fake.sentTo(testAdminChannel)[skip:] {
+		alerts = append(alerts, message.Message.Content)
+	}
+	return alerts
+}
+
+func expectAlert(t *testing.T, alerts []string, prefix string) {
+	t.Helper()
+	if len(alerts) != 1 || !strings.HasPrefix(alerts[0], prefix) {
+		t.Errorf("expected one alert starting %q, got %q", prefix, alerts)
+	}
+}
+
+func TestAlertStoppedThenRecovered(t *testing.T) {
+	fake := setupFakeDiscord(t)
+	monitor := testMonitor(t)
+
+	monitor.recordPoll(nil, errors.New("unmarshaling feed"))
+	monitor.markStopped()
+	expectAlert(t, alertsSince(fake, 0), ":rotating_light: Stopped polling")
+
+	// checked again, say by another poll finishing, the stop isn't reported twice
+	checkFeedAlerts(monitor)
+	if alerts := alertsSince(fake, 1); len(alerts) != 0 {
+		t.Errorf("stop reported again: %q", alerts)
+	}
+
+	// /feed resume starts a fresh monitor for the same URL
+	resumed := testMonitor(t)
+	resumed.recordPoll(&ZDIRssFeed{}, nil)
+	expectAlert(t, alertsSince(fake, 1), ":white_check_mark: <"+testFeedUrl+"> is being polled again")
+}
+
+func TestAlertFailingThenResponding(t *testing.T) {
+	fake := setupFakeDiscord(t)
+	monitor := testMonitor(t)
+
+	for idx := 1; idx < feedFailureThreshold; idx++ {
+		monitor.recordPoll(nil, errors.New("err: got response 429 from server"))
+	}
+	if alerts := alertsSince(fake, 0); len(alerts) != 0 {
+		t.Fatalf("alerted before %d failures: %q", feedFailureThreshold, alerts)
+	}
+	monitor.recordPoll(nil, errors.New("err: got response 429 from server"))
+	expectAlert(t, alertsSince(fake, 0), ":warning: The last 3 polls")
+
+	monitor.recordPoll(nil, errors.New("err: got response 429 from server"))
+	if alerts := alertsSince(fake, 1); len(alerts) != 0 {
+		t.Errorf("failing feed reported again: %q", alerts)
+	}
+
+	monitor.recordPoll(&ZDIRssFeed{}, nil)
+	expectAlert(t, alertsSince(fake, 1), ":white_check_mark: <"+testFeedUrl+"> is responding again")
+	monitor.recordPoll(&ZDIRssFeed{}, nil)
+	if alerts := alertsSince(fake, 2); len(alerts) != 0 {
+		t.Errorf("recovery reported again: %q", alerts)
+	}
+}
+
+func TestAlertStaleThenNewItem(t *testing.T) {
+	fake := setupFakeDiscord(t)
+	monitor := testMonitor(t)
+	monitor.lastNewItem = time.Now().Add(-staleAfter - time.Hour)
+
+	monitor.recordPoll(&ZDIRssFeed{}, nil)
+	expectAlert(t, alertsSince(fake, 0), ":hourglass: <"+testFeedUrl+"> hasn't had a new item")
+	monitor.recordPoll(&ZDIRssFeed{}, nil)
+	if alerts := alertsSince(fake, 1); len(alerts) != 0 {
+		t.Errorf("stale feed reported again: %q", alerts)
+	}
+
+	monitor.markNewItems()
+	monitor.recordPoll(&ZDIRssFeed{}, nil)
+	expectAlert(t, alertsSince(fake, 1), ":white_check_mark: <"+testFeedUrl+"> has new items again")
+}
+
+func TestAlertStaleIgnoresPausedFeeds(t *testing.T) {
+	fake := setupFakeDiscord(t)
+	monitor := testMonitor(t)
+	monitor.lastNewItem = time.Now().Add(-staleAfter - time.Hour)
+	monitor.paused = true
+
+	checkFeedAlerts(monitor)
+	if alerts := alertsSince(fake, 0); len(alerts) != 0 {
+		t.Errorf("paused feed reported as stale: %q", alerts)
+	}
+}
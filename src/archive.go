@@ -0,0 +1,349 @@
+/*
+Searchable archive of everything the bot has posted, and the public /news command for browsing it. The archive is the
+set of posts in the state file, with an in-memory inverted index over the words in each post rebuilt on startup.
+*/
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+const newsPageSize = 5
+
+// the longest /news query, so it fits whole in the paging buttons' custom IDs, which Discord limits to 100 characters
+const maxNewsQueryLength = 80
+
+var cvePattern = regexp.MustCompile(`(?i)\bCVE-\d{4}-\d{4,}\b`)
+
+func extractCVEs(text string) []string {
+	/*
+		Find every CVE ID mentioned in some text, normalised to upper case and deduplicated
+	*/
+	var cves []string
+	seen := make(map[string]bool)
+	for _, match := range cvePattern.FindAllString(text, -1) {
+		cve := strings.ToUpper(match)
+		if !seen[cve] {
+			seen[cve] = true
+			cves = append(cves, cve)
+		}
+	}
+	return cves
+}
+
+func searchTokens(text string) []string {
+	/*
+		Split text into lower case words for the index. CVE IDs are kept whole as well as split up, so searching for
+		one doesn't match every article mentioning the same year.
+	*/
+	tokens := strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+	for _, cve := range extractCVEs(text) {
+		tokens = append(tokens, strings.ToLower(cve))
+	}
+	return tokens
+}
+
+func (post postedMessage) searchText() string {
+	return strings.Join([]string{post.Title, post.Description, strings.Join(post.Categories, " "), strings.Join(post.Tags, " "), strings.Join(post.CVEs, " ")}, " ")
+}
+
+// archiveIndex maps each word to the IDs of the posts containing it
+type archiveIndex map[string]map[string]struct{}
+
+func (index archiveIndex) add(post postedMessage) {
+	for _, token := range searchTokens(post.searchText()) {
+		if index[token] == nil {
+			index[token] = make(map[string]struct{})
+		}
+		index[token][post.ItemID] = struct{}{}
+	}
+}
+
+func (index archiveIndex) remove(post postedMessage) {
+	for _, token := range searchTokens(post.searchText()) {
+		delete(index[token], post.ItemID)
+		if len(index[token]) == 0 {
+			delete(index, token)
+		}
+	}
+}
+
+func (st *stateStore) indexPost(post postedMessage) {
+	/*
+		Keep the index in step with a post that's about to be saved. Callers must hold st.mu.
+	*/
+	if old, ok := st.data.Posts[post.ItemID]; ok {
+		st.index.remove(*old)
+	}
+	if !post.Retracted {
+		st.index.add(post)
+	}
+}
+
+func (st *stateStore) searchArchive(query string) []postedMessage {
+	/*
+		Find the posts containing every word in the query, newest first
+	*/
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	tokens := searchTokens(query)
+	if len(tokens) == 0 {
+		return nil
+	}
+
+	var results []postedMessage
+	for itemID := range st.index[tokens[0]] {
+		matchesAll := true
+		for _, token := range tokens[1:] {
+			if _, ok := st.index[token][itemID]; !ok {
+				matchesAll = false
+				break
+			}
+		}
+		if matchesAll {
+			results = append(results, *st.data.Posts[itemID])
+		}
+	}
+	sortPostsNewestFirst(results)
+	return results
+}
+
+func (st *stateStore) latestArchive(source string) []postedMessage {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	var results []postedMessage
+	for _, post := range st.data.Posts {
+		if post.Retracted || (source != "" && post.Source != source) {
+			continue
+		}
+		results = append(results, *post)
+	}
+	sortPostsNewestFirst(results)
+	return results
+}
+
+func sortPostsNewestFirst(posts []postedMessage) {
+	sort.Slice(posts, func(a, b int) bool { return posts[a].PostedAt.After(posts[b].PostedAt) })
+}
+
+func newsCommand() *discordgo.ApplicationCommand {
+	sourceChoices := append(feedTypeChoices(),
+		&discordgo.ApplicationCommandOptionChoice{Name: "admin", Value: "admin"},
+		&discordgo.ApplicationCommandOptionChoice{Name: "member", Value: "member"},
+	)
+
+	return &discordgo.ApplicationCommand{
+		Name:        "news",
+		Description: "Search and browse articles posted to the news channel",
+
+		Options: []*discordgo.ApplicationCommandOption{
+			{
+				Type:        discordgo.ApplicationCommandOptionSubCommand,
+				Name:        "search",
+				Description: "Search posted articles by title, description, category, topic or CVE",
+				Options: []*discordgo.ApplicationCommandOption{
+					{
+						Type:        discordgo.ApplicationCommandOptionString,
+						Name:        "query",
+						Description: "Words the article must contain",
+						Required:    true,
+						MaxLength:   maxNewsQueryLength,
+					},
+				},
+			},
+			{
+				Type:        discordgo.ApplicationCommandOptionSubCommand,
+				Name:        "latest",
+				Description: "Show the most recently posted articles",
+				Options: []*discordgo.ApplicationCommandOption{
+					{
+						Type:        discordgo.ApplicationCommandOptionString,
+						Name:        "source",
+						Description: "Only show articles from this source",
+						Required:    false,
+						Choices:     sourceChoices,
+					},
+				},
+			},
+			{
+				Type:        discordgo.ApplicationCommandOptionSubCommand,
+				Name:        "topic",
+				Description: "Show the articles tagged with a topic",
+				Options: []*discordgo.ApplicationCommandOption{
+					{
+						Type:        discordgo.ApplicationCommandOptionString,
+						Name:        "topic",
+						Description: "The topic tag",
+						Required:    true,
+						Choices:     topicChoices(),
+					},
+				},
+			},
+			{
+				Type:        discordgo.ApplicationCommandOptionSubCommand,
+				Name:        "cve",
+				Description: "Find articles mentioning a CVE",
+				Options: []*discordgo.ApplicationCommandOption{
+					{
+						Type:        discordgo.ApplicationCommandOptionString,
+						Name:        "id",
+						Description: "The CVE ID, e.g. CVE-2023-1234",
+						Required:    true,
+						MaxLength:   maxNewsQueryLength,
+					},
+				},
+			},
+		},
+	}
+}
+
+func newsCommandHandler(s discordClient, i *discordgo.InteractionCreate) {
+	/*
+		Public command, anyone can browse the archive. The results are paged with buttons, handled by
+		newsComponentHandler.
+	*/
+	subcommand := i.ApplicationCommandData().Options[0]
+	optionMap := optionsMap(subcommand.Options)
+
+	arg := ""
+	switch subcommand.Name {
+	case "search":
+		arg = optionMap["query"].StringValue()
+	case "latest":
+		if opt, ok := optionMap["source"]; ok {
+			arg = opt.StringValue()
+		}
+	case "topic":
+		arg = optionMap["topic"].StringValue()
+	case "cve":
+		arg = strings.ToUpper(strings.TrimSpace(optionMap["id"].StringValue()))
+	}
+	if utf8.RuneCountInString(arg) > maxNewsQueryLength {
+		// Discord enforces the option's max length, this is for anything that gets past it
+		interactionRespondEphemeral(s, i, fmt.Sprintf("Searches are limited to %d characters", maxNewsQueryLength))
+		return
+	}
+
+	if err := s.respond(i.Interaction, &discordgo.InteractionResponse{
+		Type: discordgo.InteractionResponseChannelMessageWithSource,
+		Data: newsResultsPage(subcommand.Name, arg, 0),
+	}); err != nil {
+		slog.Warn("interaction response failed", "err", err)
+	}
+}
+
+func newsComponentHandler(s discordClient, i *discordgo.InteractionCreate) {
+	/*
+		Handle the previous/next buttons under a set of /news results. Everything needed to redraw the page is kept
+		in the button's custom ID, so nothing has to be remembered between clicks.
+	*/
+	parts := strings.SplitN(i.MessageComponentData().CustomID, customIdSep, 4)
+	if len(parts) != 4 {
+		return
+	}
+	page, err := strconv.Atoi(parts[2])
+	if err != nil {
+		return
+	}
+
+	if err = s.respond(i.Interaction, &discordgo.InteractionResponse{
+		Type: discordgo.InteractionResponseUpdateMessage,
+		Data: newsResultsPage(parts[1], parts[3], page),
+	}); err != nil {
+		slog.Warn("interaction response failed", "err", err)
+	}
+}
+
+func newsResultsPage(kind string, arg string, page int) *discordgo.InteractionResponseData {
+	var (
+		results []postedMessage
+		title   string
+	)
+	switch kind {
+	case "search":
+		results = store.searchArchive(arg)
+		title = fmt.Sprintf("Articles matching '%s'", arg)
+	case "latest":
+		results = store.latestArchive(arg)
+		title = "Latest articles"
+		if arg != "" {
+			title = fmt.Sprintf("Latest articles from %s", arg)
+		}
+	case "topic":
+		results = store.topicArchive(arg)
+		title = fmt.Sprintf("Articles about %s", arg)
+	case "cve":
+		results = store.searchArchive(arg)
+		title = fmt.Sprintf("Articles mentioning %s", arg)
+	}
+
+	if len(results) == 0 {
+		return &discordgo.InteractionResponseData{Content: "No articles found"}
+	}
+
+	pages := (len(results) + newsPageSize - 1) / newsPageSize
+	if page < 0 {
+		page = 0
+	} else if page >= pages {
+		page = pages - 1
+	}
+
+	embed := &discordgo.MessageEmbed{
+		Type:   discordgo.EmbedTypeRich,
+		Title:  title,
+		Footer: &discordgo.MessageEmbedFooter{Text: fmt.Sprintf("Page %d of %d", page+1, pages)},
+	}
+	end := (page + 1) * newsPageSize
+	if end > len(results) {
+		end = len(results)
+	}
+	for _, post := range results[page*newsPageSize : end] {
+		embed.Fields = append(embed.Fields, &discordgo.MessageEmbedField{
+			Name:  truncate(post.Title, 256),
+			Value: fmt.Sprintf("%s\n%s, <t:%d:d>", post.Link, post.Source, post.PostedAt.Unix()),
+		})
+	}
+
+	return &discordgo.InteractionResponseData{
+		Embeds: []*discordgo.MessageEmbed{embed},
+		Components: []discordgo.MessageComponent{
+			discordgo.ActionsRow{
+				Components: []discordgo.MessageComponent{
+					discordgo.Button{
+						Label:    "Previous",
+						Style:    discordgo.SecondaryButton,
+						Disabled: page == 0,
+						CustomID: strings.Join([]string{"news", kind, strconv.Itoa(page - 1), arg}, customIdSep),
+					},
+					discordgo.Button{
+						Label:    "Next",
+						Style:    discordgo.SecondaryButton,
+						Disabled: page == pages-1,
+						CustomID: strings.Join([]string{"news", kind, strconv.Itoa(page + 1), arg}, customIdSep),
+					},
+				},
+			},
+		},
+	}
+}
+
+func truncate(text string, length int) string {
+	runes := []rune(text)
+	if len(runes) <= length {
+		return text
+	}
+	return string(runes[:length-1]) + "…"
+}
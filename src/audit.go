@@ -0,0 +1,240 @@
+/*
+Audit trail of every admin action and everything the bot posts. Entries are appended to a JSON lines file, can be
+searched with /audit, and are optionally mirrored to a Discord channel as they happen.
+*/
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+const defaultAuditFile = "audit.jsonl"
+
+// auditEntry is one action, by a person or by the bot itself when UserID is empty
+type auditEntry struct {
+	Time       time.Time         `json:"time"`
+	UserID     string            `json:"user_id,omitempty"`
+	Username   string            `json:"username,omitempty"`
+	Command    string            `json:"command"`
+	Args       map[string]string `json:"args,omitempty"`
+	MessageIDs []string          `json:"message_ids,omitempty"`
+	Result     string            `json:"result,omitempty"`
+}
+
+var (
+	auditMu        sync.Mutex
+	auditFile      = defaultAuditFile
+	auditChannelId string
+)
+
+func recordAudit(entry auditEntry) {
+	/*
+		Append an entry to the audit file, and post it to the audit channel if one is configured. Failing to audit
+		is logged but never stops the action itself.
+	*/
+	if entry.Time.IsZero() {
+		entry.Time = time.Now()
+	}
+	line, err := json.Marshal(entry)
+	if err != nil {
+		slog.Error("encoding audit entry", "err", err)
+		return
+	}
+
+	auditMu.Lock()
+	file, err := os.OpenFile(auditFile, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o600)
+	if err == nil {
+		_, err = file.Write(append(line, '\n'))
+		file.Close()
+	}
+	auditMu.Unlock()
+	if err != nil {
+		slog.Error("writing audit log", "err", err)
+	}
+
+	if auditChannelId != "" && discord != nil {
+		if _, err = discord.sendMessage(auditChannelId, &discordgo.MessageSend{Content: entry.describe()}); err != nil {
+			slog.Warn("mirroring audit entry", "channel", auditChannelId, "err", err)
+		}
+	}
+}
+
+func auditInteraction(i *discordgo.InteractionCreate, result string, messageIDs ...string) {
+	/*
+		Audit a slash command, taking the command name, subcommand and arguments from the interaction itself
+	*/
+	entry := auditEntry{Result: result, MessageIDs: messageIDs, Args: make(map[string]string)}
+	if i.Member != nil && i.Member.User != nil {
+		entry.UserID = i.Member.User.ID
+		entry.Username = i.Member.User.Username
+	}
+
+	switch i.Type {
+	case discordgo.InteractionApplicationCommand:
+		data := i.ApplicationCommandData()
+		entry.Command = "/" + data.Name
+		options := data.Options
+		if len(options) == 1 && options[0].Type == discordgo.ApplicationCommandOptionSubCommand {
+			entry.Command += " " + options[0].Name
+			options = options[0].Options
+		}
+		for _, opt := range options {
+			entry.Args[opt.Name] = fmt.Sprint(opt.Value)
+		}
+	case discordgo.InteractionMessageComponent:
+		entry.Command = i.MessageComponentData().CustomID
+	case discordgo.InteractionModalSubmit:
+		entry.Command = i.ModalSubmitData().CustomID
+		for name, value := range modalValues(i) {
+			entry.Args[name] = value
+		}
+	}
+	recordAudit(entry)
+}
+
+func (entry auditEntry) describe() string {
+	who := "bot"
+	if entry.UserID != "" {
+		who = fmt.Sprintf("<@%s>", entry.UserID)
+	}
+
+	args := make([]string, 0, len(entry.Args))
+	for name, value := range entry.Args {
+		args = append(args, fmt.Sprintf("%s=%s", name, value))
+	}
+	sort.Strings(args)
+
+	line := fmt.Sprintf("<t:%d:f> %s `%s`", entry.Time.Unix(), who, entry.Command)
+	if len(args) > 0 {
+		line += " " + strings.Join(args, " ")
+	}
+	if entry.Result != "" {
+		line += " → " + entry.Result
+	}
+	if len(entry.MessageIDs) > 0 {
+		line += fmt.Sprintf(" (messages %s)", strings.Join(entry.MessageIDs, ", "))
+	}
+	return line
+}
+
+func queryAudit(userId string, command string, limit int) ([]auditEntry, error) {
+	/*
+		Read back the newest entries matching a user and command prefix, either of which may be empty
+	*/
+	auditMu.Lock()
+	defer auditMu.Unlock()
+
+	file, err := os.Open(auditFile)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var entries []auditEntry
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1<<20)
+	for scanner.Scan() {
+		var entry auditEntry
+		if err = json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			continue
+		}
+		if userId != "" && entry.UserID != userId {
+			continue
+		}
+		if command != "" && !strings.HasPrefix(strings.TrimPrefix(entry.Command, "/"), strings.TrimPrefix(command, "/")) {
+			continue
+		}
+		entries = append(entries, entry)
+		if len(entries) > limit {
+			entries = entries[1:]
+		}
+	}
+
+	// newest first
+	for a, b := 0, len(entries)-1; a < b; a, b = a+1, b-1 {
+		entries[a], entries[b] = entries[b], entries[a]
+	}
+	return entries, scanner.Err()
+}
+
+var auditCommand = &discordgo.ApplicationCommand{
+	Name:        "audit",
+	Description: "Show recent admin actions and bot posts",
+
+	Options: []*discordgo.ApplicationCommandOption{
+		{
+			Type:        discordgo.ApplicationCommandOptionUser,
+			Name:        "user",
+			Description: "Only show actions by this member",
+			Required:    false,
+		},
+		{
+			Type:        discordgo.ApplicationCommandOptionString,
+			Name:        "command",
+			Description: "Only show this command, e.g. send or feed add",
+			Required:    false,
+		},
+		{
+			Type:        discordgo.ApplicationCommandOptionInteger,
+			Name:        "limit",
+			Description: "How many entries to show, at most 25",
+			Required:    false,
+		},
+	},
+}
+
+func auditCommandHandler(s discordClient, i *discordgo.InteractionCreate) {
+	if !checkInteractionCapability(s, i, capModerate) {
+		return
+	}
+
+	optionMap := interactionOptions(i)
+	userId, command, limit := "", "", 10
+	if opt, ok := optionMap["user"]; ok {
+		userId = opt.UserValue(nil).ID
+	}
+	if opt, ok := optionMap["command"]; ok {
+		command = opt.StringValue()
+	}
+	if opt, ok := optionMap["limit"]; ok {
+		limit = int(opt.IntValue())
+	}
+	if limit < 1 || limit > 25 {
+		limit = 25
+	}
+
+	entries, err := queryAudit(userId, command, limit)
+	if err != nil {
+		slog.Error("reading audit log", "err", err)
+		interactionRespond(s, i, "Failed to read the audit log")
+		return
+	}
+	if len(entries) == 0 {
+		interactionRespond(s, i, "No matching audit entries")
+		return
+	}
+
+	lines := make([]string, 0, len(entries))
+	for _, entry := range entries {
+		lines = append(lines, entry.describe())
+	}
+	interactionRespondEmbeds(s, i, []*discordgo.MessageEmbed{{
+		Type:        discordgo.EmbedTypeRich,
+		Title:       "Audit log",
+		Description: truncate(strings.Join(lines, "\n"), 4096),
+	}})
+}
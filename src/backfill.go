@@ -0,0 +1,132 @@
+/*
+What to post from a feed's first snapshot. Normally a new feed's current items are taken as already seen, so only
+articles published after it was added are posted, but a feed can be added with a backfill policy to introduce it with a
+few of its recent articles.
+*/
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+const (
+	backfillNone   = "none"
+	backfillNewest = "newest"
+	backfillSince  = "since"
+)
+
+func backfillOptions() []*discordgo.ApplicationCommandOption {
+	return []*discordgo.ApplicationCommandOption{
+		{
+			Type:        discordgo.ApplicationCommandOptionString,
+			Name:        "backfill",
+			Description: "What to post from the feed's current items, nothing by default",
+			Required:    false,
+			Choices: []*discordgo.ApplicationCommandOptionChoice{
+				{Name: "nothing", Value: backfillNone},
+				{Name: "the newest items, up to count", Value: backfillNewest},
+				{Name: "everything published since a date", Value: backfillSince},
+			},
+		},
+		{
+			Type:        discordgo.ApplicationCommandOptionInteger,
+			Name:        "count",
+			Description: "How many of the newest items to post, for backfill newest",
+			Required:    false,
+			MinValue:    &minBackfillCount,
+		},
+		{
+			Type:        discordgo.ApplicationCommandOptionString,
+			Name:        "since",
+			Description: "Post items published on or after this date, like 2024-01-31, for backfill since",
+			Required:    false,
+		},
+	}
+}
+
+var minBackfillCount = 1.0
+
+func (feed *feedConfig) setBackfill(optionMap map[string]*discordgo.ApplicationCommandInteractionDataOption) error {
+	/*
+		Fill in the feed's backfill policy from the /feed add options, checking the policy has what it needs
+	*/
+	opt, ok := optionMap["backfill"]
+	if !ok || opt.StringValue() == backfillNone {
+		return nil
+	}
+	feed.Backfill = opt.StringValue()
+
+	switch feed.Backfill {
+	case backfillNewest:
+		count, ok := optionMap["count"]
+		if !ok {
+			return errors.New("backfill newest needs a count")
+		}
+		feed.BackfillCount = int(count.IntValue())
+	case backfillSince:
+		since, ok := optionMap["since"]
+		if !ok {
+			return errors.New("backfill since needs a date")
+		}
+		date, err := time.Parse(time.DateOnly, strings.TrimSpace(since.StringValue()))
+		if err != nil {
+			return fmt.Errorf("'%s' isn't a date like 2024-01-31", since.StringValue())
+		}
+		feed.BackfillSince = date
+	}
+	return nil
+}
+
+func (feed feedConfig) backfillItems(items []discordMessageData) []discordMessageData {
+	/*
+		Pick the items from a first snapshot to post under the feed's policy. items are newest first, as the outlet
+		lists them. Items without a date can't be placed, so they're left out of a since backfill.
+	*/
+	switch feed.Backfill {
+	case backfillNewest:
+		if len(items) > feed.BackfillCount {
+			items = items[:feed.BackfillCount]
+		}
+		return items
+	case backfillSince:
+		var recent []discordMessageData
+		for _, item := range items {
+			if !item.Published.IsZero() && !item.Published.Before(feed.BackfillSince) {
+				recent = append(recent, item)
+			}
+		}
+		return recent
+	}
+	return nil
+}
+
+func (feed feedConfig) describeBackfill() string {
+	switch feed.Backfill {
+	case backfillNewest:
+		return fmt.Sprintf("posting its newest %d item(s)", feed.BackfillCount)
+	case backfillSince:
+		return fmt.Sprintf("posting its items since %s", feed.BackfillSince.Format(time.DateOnly))
+	}
+	return "its current items taken as already seen"
+}
+
+func (st *stateStore) markBackfilled(url string) (feedConfig, bool, error) {
+	/*
+		Record that the feed's first snapshot has been dealt with. Returns the feed, and true the first time only,
+		so a feed is backfilled once however often the bot restarts.
+	*/
+	first := false
+	feed, ok, err := st.updateFeed(url, func(feed *feedConfig) {
+		first = !feed.Backfilled
+		feed.Backfilled = true
+	})
+	if !ok || !first {
+		return feedConfig{}, false, err
+	}
+	return feed, true, err
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"log/slog"
+	"testing"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+func datedItems() []discordMessageData {
+	return []discordMessageData{
+		{ID: "c", Title: "C", Link: "https://example.com/c", Published: time.Date(2024, 3, 1, 9, 0, 0, 0, time.UTC)},
+		{ID: "undated", Title: "Undated", Link: "https://example.com/undated"},
+		{ID: "b", Title: "B", Link: "https://example.com/b", Published: time.Date(2024, 2, 1, 0, 0, 0, 0, time.UTC)},
+		{ID: "a", Title: "A", Link: "https://example.com/a", Published: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)},
+	}
+}
+
+func TestBackfillItems(t *testing.T) {
+	tests := []struct {
+		name string
+		feed feedConfig
+		want []string
+	}{
+		{"no policy", feedConfig{}, nil},
+		{"newest", feedConfig{Backfill: backfillNewest, BackfillCount: 2}, []string{"c", "undated"}},
+		{"newest more than the feed has", feedConfig{Backfill: backfillNewest, BackfillCount: 10}, []string{"c", "undated", "b", "a"}},
+		{"since", feedConfig{Backfill: backfillSince, BackfillSince: time.Date(2024, 2, 1, 0, 0, 0, 0, time.UTC)}, []string{"c", "b"}},
+	}
+	for _, test := range tests {
+		var got []string
+		for _, item := range test.feed.backfillItems(datedItems()) {
+			got = append(got, item.ID)
+		}
+		if len(got) != len(test.want) {
+			t.Errorf("%s: got %v, want %v", test.name, got, test.want)
+			continue
+		}
+		for idx := range got {
+			if got[idx] != test.want[idx] {
+				t.Errorf("%s: got %v, want %v", test.name, got, test.want)
+				break
+			}
+		}
+	}
+}
+
+func TestSetBackfill(t *testing.T) {
+	integerOption := func(name string, value int) *discordgo.ApplicationCommandInteractionDataOption {
+		return &discordgo.ApplicationCommandInteractionDataOption{Name: name, Type: discordgo.ApplicationCommandOptionInteger, Value: float64(value)}
+	}
+
+	var feed feedConfig
+	if err := feed.setBackfill(optionsMap([]*discordgo.ApplicationCommandInteractionDataOption{
+		stringOption("backfill", backfillSince), stringOption("since", "2024-01-31"),
+	})); err != nil || !feed.BackfillSince.Equal(time.Date(2024, 1, 31, 0, 0, 0, 0, time.UTC)) {
+		t.Errorf("since backfill not set, got %+v, %v", feed, err)
+	}
+
+	feed = feedConfig{}
+	if err := feed.setBackfill(optionsMap([]*discordgo.ApplicationCommandInteractionDataOption{
+		stringOption("backfill", backfillNewest), integerOption("count", 3),
+	})); err != nil || feed.BackfillCount != 3 {
+		t.Errorf("newest backfill not set, got %+v, %v", feed, err)
+	}
+
+	invalid := [][]*discordgo.ApplicationCommandInteractionDataOption{
+		{stringOption("backfill", backfillNewest)},
+		{stringOption("backfill", backfillSince)},
+		{stringOption("backfill", backfillSince), stringOption("since", "last tuesday")},
+	}
+	for _, options := range invalid {
+		feed = feedConfig{}
+		if err := feed.setBackfill(optionsMap(options)); err == nil {
+			t.Errorf("%v was accepted", options[len(options)-1].Value)
+		}
+	}
+}
+
+func TestBackfillOnlyOnce(t *testing.T) {
+	fake := setupFakeDiscord(t)
+	feedUrl := "https://example.com/zdi"
+	if err := store.saveFeed(feedConfig{URL: feedUrl, Type: "zdi", Backfill: backfillNewest, BackfillCount: 1}); err != nil {
+		t.Fatal(err)
+	}
+	feed, err := readFeedFile("../rss_tests/zdi/newfeed.xml", feedTypes["zdi"])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	monitor := &feedMonitor{url: feedUrl, feedType: "zdi", log: slog.Default()}
+	monitor.backfill(feed)
+	news := fake.sentTo(testNewsChannel)
+	if len(news) != 1 || news[0].Message.Embeds[0].Title != feed.messageData()[0].Title {
+		t.Fatalf("expected the newest item to be backfilled, got %+v", news)
+	}
+
+	// a restart starts a new monitor, which mustn't backfill again
+	monitor = &feedMonitor{url: feedUrl, feedType: "zdi", log: slog.Default()}
+	monitor.backfill(feed)
+	if len(fake.sentTo(testNewsChannel)) != 1 {
+		t.Error("feed was backfilled twice")
+	}
+}
@@ -0,0 +1,227 @@
+/*
+The safety valve for a feed that suddenly lists far more new items than usual, which happens when an outlet resets or
+rebuilds its feed. Rather than flooding the news channel, the new items are held and the admin channel is asked
+whether to post all of them, only the newest few, or none.
+*/
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+const (
+	defaultBurstLimit = 20
+	// a batch nobody has dealt with in this long is dropped, its items taken as seen
+	heldBurstTTL = 7 * 24 * time.Hour
+)
+
+// heldBurst is a batch of new items waiting for an admin to decide what to do with it. Held bursts are kept in the
+// state file, so a batch survives a restart
+type heldBurst struct {
+	ID      string               `json:"id"`
+	FeedURL string               `json:"feed_url"`
+	Items   []discordMessageData `json:"items"`
+	Limit   int                  `json:"limit"`
+	HeldAt  time.Time            `json:"held_at"`
+}
+
+func (feed feedConfig) burstLimit() int {
+	if feed.BurstLimit > 0 {
+		return feed.BurstLimit
+	}
+	return defaultBurstLimit
+}
+
+func holdBurst(feedUrl string, items []discordMessageData) []discordMessageData {
+	/*
+		Check a poll's items against the feed's burst limit. Edits to articles always go through, but if there are
+		more new articles than the limit they're held back and the admins are asked about them. Returns the items
+		that can be posted straight away, which is all of them if the admins can't be asked, as held items nobody
+		knows about would never be posted.
+	*/
+	for _, expired := range store.expireBursts(time.Now().Add(-heldBurstTTL)) {
+		slog.Warn("dropping held burst nobody dealt with", "feed", expired.FeedURL, "items", len(expired.Items), "held_at", expired.HeldAt)
+	}
+
+	limit := defaultBurstLimit
+	if feed, ok := store.lookupFeed(feedUrl); ok {
+		limit = feed.burstLimit()
+	}
+
+	var added, updated []discordMessageData
+	for _, item := range items {
+		if item.Updated {
+			updated = append(updated, item)
+		} else {
+			added = append(added, item)
+		}
+	}
+	if len(added) <= limit {
+		return items
+	}
+
+	burst := &heldBurst{
+		ID:      strconv.FormatInt(time.Now().UnixNano(), 36),
+		FeedURL: feedUrl,
+		Items:   added,
+		Limit:   limit,
+		HeldAt:  time.Now(),
+	}
+	if discord == nil {
+		slog.Warn("too many new items but no one to ask, posting them", "feed", feedUrl, "items", len(added), "limit", limit)
+		return items
+	}
+	if err := store.saveBurst(*burst); err != nil {
+		slog.Error("saving held burst, posting its items instead", "feed", feedUrl, "err", err)
+		return items
+	}
+	slog.Warn("holding burst of new items", "feed", feedUrl, "items", len(added), "limit", limit)
+	if _, err := discord.sendMessage(adminChannelId, &discordgo.MessageSend{
+		Content:    fmt.Sprintf(":warning: <%s> has %d new items, more than its limit of %d. Nothing has been posted yet.", feedUrl, len(added), limit),
+		Embeds:     []*discordgo.MessageEmbed{burst.embed()},
+		Components: burst.components(),
+	}); err != nil {
+		slog.Error("asking admins about held burst, posting its items instead", "feed", feedUrl, "err", err)
+		store.takeBurst(burst.ID)
+		return items
+	}
+	return updated
+}
+
+func (st *stateStore) saveBurst(burst heldBurst) error {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	st.data.HeldBursts[burst.ID] = &burst
+	return st.save()
+}
+
+func (st *stateStore) takeBurst(id string) (heldBurst, bool) {
+	/*
+		Remove a held burst and return it, so only the first admin to click a button gets to deal with it
+	*/
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	burst, ok := st.data.HeldBursts[id]
+	if !ok {
+		return heldBurst{}, false
+	}
+	delete(st.data.HeldBursts, id)
+	if err := st.save(); err != nil {
+		slog.Error("saving state", "err", err)
+	}
+	return *burst, true
+}
+
+func (st *stateStore) expireBursts(before time.Time) []heldBurst {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	var expired []heldBurst
+	for id, burst := range st.data.HeldBursts {
+		if burst.HeldAt.Before(before) {
+			expired = append(expired, *burst)
+			delete(st.data.HeldBursts, id)
+		}
+	}
+	if len(expired) > 0 {
+		if err := st.save(); err != nil {
+			slog.Error("saving state", "err", err)
+		}
+	}
+	return expired
+}
+
+func (burst *heldBurst) embed() *discordgo.MessageEmbed {
+	/*
+		The titles of the held items, newest first, as many as fit in an embed
+	*/
+	var lines []string
+	length := 0
+	for idx, item := range burst.Items {
+		line := fmt.Sprintf("%d. %s", idx+1, truncate(item.Title, 200))
+		if length+len(line) > 3900 {
+			lines = append(lines, fmt.Sprintf("…and %d more", len(burst.Items)-idx))
+			break
+		}
+		lines = append(lines, line)
+		length += len(line) + 1
+	}
+	return &discordgo.MessageEmbed{
+		Type:        discordgo.EmbedTypeRich,
+		Title:       "Held items",
+		Description: strings.Join(lines, "\n"),
+	}
+}
+
+func (burst *heldBurst) components() []discordgo.MessageComponent {
+	customId := func(action string) string {
+		return strings.Join([]string{"burst", action, burst.ID}, customIdSep)
+	}
+	return []discordgo.MessageComponent{
+		discordgo.ActionsRow{
+			Components: []discordgo.MessageComponent{
+				discordgo.Button{Label: fmt.Sprintf("Post all %d", len(burst.Items)), Style: discordgo.DangerButton, CustomID: customId("all")},
+				discordgo.Button{Label: fmt.Sprintf("Post newest %d", burst.Limit), Style: discordgo.PrimaryButton, CustomID: customId("newest")},
+				discordgo.Button{Label: "Mark all as seen", Style: discordgo.SecondaryButton, CustomID: customId("seen")},
+			},
+		},
+	}
+}
+
+func burstComponentHandler(s discordClient, i *discordgo.InteractionCreate) {
+	/*
+		The buttons under a held burst. Posting can take a while, so the message is updated to say what's happening
+		first, and again once the items are out.
+	*/
+	parts := strings.Split(i.MessageComponentData().CustomID, customIdSep)
+	if len(parts) != 3 {
+		return
+	}
+	if !checkInteractionCapability(s, i, capManageFeeds) {
+		return
+	}
+
+	burst, ok := store.takeBurst(parts[2])
+	if !ok {
+		interactionRespondEphemeral(s, i, "This batch has already been dealt with, or expired before anyone did")
+		return
+	}
+
+	var items []discordMessageData
+	switch parts[1] {
+	case "all":
+		items = burst.Items
+	case "newest":
+		items = burst.Items[:burst.Limit]
+	}
+
+	content := fmt.Sprintf("Marked %d items from <%s> as seen, nothing was posted", len(burst.Items), burst.FeedURL)
+	if len(items) > 0 {
+		content = fmt.Sprintf("Posting %d of %d items from <%s>…", len(items), len(burst.Items), burst.FeedURL)
+	}
+	if err := s.respond(i.Interaction, &discordgo.InteractionResponse{
+		Type: discordgo.InteractionResponseUpdateMessage,
+		Data: &discordgo.InteractionResponseData{
+			Content:    content,
+			Components: []discordgo.MessageComponent{},
+		},
+	}); err != nil {
+		slog.Warn("interaction response failed", "err", err)
+	}
+	if len(items) == 0 {
+		auditInteraction(i, "marked seen")
+		return
+	}
+
+	messageIDs := submitNewRssContent(resolveItemLinks(botContext, burst.FeedURL, items))
+	auditInteraction(i, fmt.Sprintf("posted %d of %d", len(messageIDs), len(burst.Items)), messageIDs...)
+	interactionEdit(s, i, fmt.Sprintf("Posted %d of %d items from <%s>", len(messageIDs), len(burst.Items), burst.FeedURL))
+}
@@ -0,0 +1,184 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+const testFeedUrl = "https://example.com/feed"
+
+func burstItems(count int) []discordMessageData {
+	items := make([]discordMessageData, 0, count)
+	for idx := 0; idx < count; idx++ {
+		items = append(items, discordMessageData{ID: fmt.Sprintf("item-%d", idx), Title: fmt.Sprintf("Item %d", idx), Link: fmt.Sprintf("https://example.com/%d", idx)})
+	}
+	return items
+}
+
+// holdTestBurst holds a burst of count items against a feed with the given limit, returning the burst's button IDs
+func holdTestBurst(t *testing.T, fake *recordingClient, limit int, count int) map[string]string {
+	t.Helper()
+	if err := store.saveFeed(feedConfig{URL: testFeedUrl, Type: "zdi", BurstLimit: limit}); err != nil {
+		t.Fatal(err)
+	}
+	if passed := holdBurst(testFeedUrl, burstItems(count)); len(passed) != 0 {
+		t.Fatalf("expected the whole burst to be held, %d items passed", len(passed))
+	}
+
+	asks := fake.sentTo(testAdminChannel)
+	if len(asks) != 1 {
+		t.Fatalf("expected the admins to be asked once, got %d messages", len(asks))
+	}
+	buttons := map[string]string{}
+	for _, component := range asks[0].Message.Components[0].(discordgo.ActionsRow).Components {
+		button := component.(discordgo.Button)
+		action := strings.Split(button.CustomID, customIdSep)[1]
+		buttons[action] = button.CustomID
+	}
+	return buttons
+}
+
+func TestHoldBurstWithinLimit(t *testing.T) {
+	fake := setupFakeDiscord(t)
+	if err := store.saveFeed(feedConfig{URL: testFeedUrl, Type: "zdi", BurstLimit: 3}); err != nil {
+		t.Fatal(err)
+	}
+
+	items := burstItems(3)
+	// edits don't count towards the limit
+	items = append(items, discordMessageData{ID: "edited", Title: "Edited", Updated: true})
+	if passed := holdBurst(testFeedUrl, items); len(passed) != len(items) {
+		t.Errorf("expected all %d items to pass, got %d", len(items), len(passed))
+	}
+	if len(fake.sent) != 0 {
+		t.Errorf("admins shouldn't be asked about a batch within the limit, got %+v", fake.sent)
+	}
+}
+
+func TestHoldBurstLetsEditsThrough(t *testing.T) {
+	setupFakeDiscord(t)
+
+	items := append(burstItems(defaultBurstLimit+1), discordMessageData{ID: "edited", Title: "Edited", Updated: true})
+	passed := holdBurst("https://example.com/unknown", items)
+	if len(passed) != 1 || passed[0].ID != "edited" {
+		t.Errorf("expected only the edit to pass the default limit, got %+v", passed)
+	}
+}
+
+func TestBurstPostNewest(t *testing.T) {
+	fake := setupFakeDiscord(t)
+	buttons := holdTestBurst(t, fake, 2, 5)
+
+	interactionHandler(fake, componentInteraction(buttons["newest"], testMember("admin", discordgo.PermissionAdministrator)))
+	news := fake.sentTo(testNewsChannel)
+	if len(news) != 2 || news[0].Message.Embeds[0].Title != "Item 1" || news[1].Message.Embeds[0].Title != "Item 0" {
+		t.Fatalf("expected the newest 2 items to be posted oldest first, got %+v", news)
+	}
+	if len(fake.responseEdits) != 1 || !strings.HasPrefix(*fake.responseEdits[0].Content, "Posted 2 of 5") {
+		t.Errorf("unexpected final message %+v", fake.responseEdits)
+	}
+
+	// the batch can only be dealt with once
+	interactionHandler(fake, componentInteraction(buttons["all"], testMember("admin", discordgo.PermissionAdministrator)))
+	if response := fake.lastResponse(t); !strings.HasPrefix(response, "This batch has already been dealt with") {
+		t.Errorf("unexpected response %q", response)
+	}
+	if len(fake.sentTo(testNewsChannel)) != 2 {
+		t.Error("batch was posted twice")
+	}
+}
+
+func TestBurstPostAll(t *testing.T) {
+	fake := setupFakeDiscord(t)
+	buttons := holdTestBurst(t, fake, 2, 5)
+
+	interactionHandler(fake, componentInteraction(buttons["all"], testMember("admin", discordgo.PermissionAdministrator)))
+	if news := fake.sentTo(testNewsChannel); len(news) != 5 {
+		t.Errorf("expected all 5 items to be posted, got %d", len(news))
+	}
+}
+
+func TestBurstMarkSeen(t *testing.T) {
+	fake := setupFakeDiscord(t)
+	buttons := holdTestBurst(t, fake, 2, 5)
+
+	interactionHandler(fake, componentInteraction(buttons["seen"], testMember("admin", discordgo.PermissionAdministrator)))
+	if news := fake.sentTo(testNewsChannel); len(news) != 0 {
+		t.Errorf("expected nothing to be posted, got %d", len(news))
+	}
+	if response := fake.lastResponse(t); !strings.HasPrefix(response, "Marked 5 items") {
+		t.Errorf("unexpected response %q", response)
+	}
+}
+
+func TestBurstNeedsManageFeeds(t *testing.T) {
+	fake := setupFakeDiscord(t)
+	buttons := holdTestBurst(t, fake, 2, 5)
+
+	interactionHandler(fake, componentInteraction(buttons["all"], testMember("member", 0)))
+	if len(fake.sentTo(testNewsChannel)) != 0 {
+		t.Error("a member without manage_feeds released the batch")
+	}
+
+	// and the batch is still there for someone who can
+	interactionHandler(fake, componentInteraction(buttons["seen"], testMember("admin", discordgo.PermissionAdministrator)))
+	if response := fake.lastResponse(t); !strings.HasPrefix(response, "Marked 5 items") {
+		t.Errorf("unexpected response %q", response)
+	}
+}
+
+func TestHoldBurstPostsWhenAdminsCantBeAsked(t *testing.T) {
+	fake := setupFakeDiscord(t)
+	fake.failing = true
+
+	if passed := holdBurst(testFeedUrl, burstItems(defaultBurstLimit+1)); len(passed) != defaultBurstLimit+1 {
+		t.Errorf("expected the items to be posted when the admins can't be asked, %d passed", len(passed))
+	}
+	if len(store.data.HeldBursts) != 0 {
+		t.Errorf("a burst nobody was asked about was kept, got %+v", store.data.HeldBursts)
+	}
+}
+
+func TestHeldBurstSurvivesRestart(t *testing.T) {
+	fake := setupFakeDiscord(t)
+	buttons := holdTestBurst(t, fake, 2, 5)
+
+	var err error
+	if store, err = loadStateStore(store.path); err != nil {
+		t.Fatal(err)
+	}
+	interactionHandler(fake, componentInteraction(buttons["all"], testMember("admin", discordgo.PermissionAdministrator)))
+	if news := fake.sentTo(testNewsChannel); len(news) != 5 {
+		t.Errorf("expected the batch to be posted after a restart, got %d", len(news))
+	}
+}
+
+func TestHeldBurstExpires(t *testing.T) {
+	fake := setupFakeDiscord(t)
+	buttons := holdTestBurst(t, fake, 2, 5)
+	for _, burst := range store.data.HeldBursts {
+		burst.HeldAt = time.Now().Add(-heldBurstTTL - time.Hour)
+	}
+
+	// the next poll clears it out
+	holdBurst(testFeedUrl, burstItems(1))
+	interactionHandler(fake, componentInteraction(buttons["all"], testMember("admin", discordgo.PermissionAdministrator)))
+	if response := fake.lastResponse(t); !strings.Contains(response, "expired") {
+		t.Errorf("unexpected response %q", response)
+	}
+	if len(fake.sentTo(testNewsChannel)) != 0 {
+		t.Error("an expired batch was posted")
+	}
+}
+
+func TestBurstEmbedFits(t *testing.T) {
+	burst := &heldBurst{ID: "id", Items: burstItems(500), Limit: 20, FeedURL: testFeedUrl}
+	burst.Items[0].Title = strings.Repeat("long ", 100)
+	if description := burst.embed().Description; len(description) > 4096 || !strings.Contains(description, "more") {
+		t.Errorf("embed of %d bytes doesn't fit, or doesn't say items were left out", len(description))
+	}
+}
@@ -0,0 +1,356 @@
+/*
+Command line interface. With no subcommand, or with run, the bot connects to Discord and polls forever as it always
+has. The other subcommands work offline, so feed parsers can be developed and debugged without a Discord token.
+*/
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"sort"
+	"strings"
+	"syscall"
+	"time"
+)
+
+const cliUsage = `usage: %[1]s [command] [arguments]
+
+commands:
+  run                       connect to Discord and poll the feeds (the default)
+  validate-config           check the environment variables and state file without connecting
+  test-feed [-type t] [-json] <url|file>
+                            fetch or read a feed and print its items
+  dry-run [-feed url] [-interval d]
+                            poll the configured feeds, printing what would be posted instead of posting it
+  replay [-type t] <fixture dir | old.xml new.xml...>
+                            feed saved snapshots of a feed through the parser, printing what would be posted
+  mock-outlet [-listen addr] <scenario.json>
+                            serve mock feeds that change as the scenario file describes
+
+Configuration is read from the environment, see the README.
+`
+
+func runCli(args []string) int {
+	cfg, problems := configFromEnv()
+	if err := setupLogging(cfg.LogLevel, cfg.LogFormat, cfg.DiscordToken); err != nil {
+		problems = append(problems, err)
+	}
+
+	command := "run"
+	if len(args) > 0 {
+		command, args = args[0], args[1:]
+	}
+
+	switch command {
+	case "validate-config":
+		return validateConfig(cfg, problems)
+	case "help", "-h", "-help", "--help":
+		fmt.Printf(cliUsage, filepath.Base(os.Args[0]))
+		return 0
+	case "run", "test-feed", "dry-run", "replay", "mock-outlet":
+	default:
+		fmt.Fprintf(os.Stderr, "unknown command '%s'\n\n", command)
+		fmt.Fprintf(os.Stderr, cliUsage, filepath.Base(os.Args[0]))
+		return 2
+	}
+
+	if command == "run" {
+		problems = append(problems, cfg.discordProblems()...)
+	}
+	if len(problems) > 0 {
+		for _, problem := range problems {
+			fmt.Fprintln(os.Stderr, problem)
+		}
+		return 1
+	}
+
+	switch command {
+	case "test-feed":
+		return testFeed(args)
+	case "dry-run":
+		return dryRun(cfg, args)
+	case "replay":
+		return replay(cfg, args)
+	case "mock-outlet":
+		return mockOutletCommand(args)
+	}
+
+	for _, warning := range cfg.discordWarnings() {
+		fmt.Fprintln(os.Stderr, "warning:", warning)
+	}
+	runBot(cfg)
+	return 0
+}
+
+func validateConfig(cfg botConfig, problems []error) int {
+	/*
+		Report every problem with the configuration, without connecting to anything
+	*/
+	problems = append(problems, cfg.discordProblems()...)
+	warnings := cfg.discordWarnings()
+
+	if st, err := loadStateStore(cfg.StateFile); err != nil {
+		problems = append(problems, fmt.Errorf("err: reading state file '%v' - %v", cfg.StateFile, err))
+	} else {
+		for _, feed := range st.listFeeds() {
+			if _, ok := feedTypes[feed.Type]; !ok {
+				problems = append(problems, fmt.Errorf("err: feed %v has unknown type '%v'", feed.URL, feed.Type))
+			}
+		}
+		if !st.data.FeedsSeeded {
+			warnings = append(warnings, fmt.Sprintf("%v has no feeds yet, the default feeds will be added on first run", cfg.StateFile))
+		}
+	}
+
+	for _, warning := range warnings {
+		fmt.Println("warning:", warning)
+	}
+	for _, problem := range problems {
+		fmt.Println(problem)
+	}
+	if len(problems) > 0 {
+		return 1
+	}
+	fmt.Println("configuration OK")
+	return 0
+}
+
+func guessFeedType(source string) string {
+	/*
+		Work out a feed's type from its URL if it's one of the defaults, or from the name of the directory it's saved
+		in, like rss_tests/project_zero
+	*/
+	if feedType, ok := defaultFeeds[source]; ok {
+		return feedType
+	}
+	dir := filepath.Base(filepath.Dir(source))
+	if info, err := os.Stat(source); err == nil && info.IsDir() {
+		dir = filepath.Base(source)
+	}
+	feedType := strings.ReplaceAll(strings.ToLower(dir), "_", "")
+	if _, ok := feedTypes[feedType]; ok {
+		return feedType
+	}
+	return ""
+}
+
+func feedTypeFor(feedType string, source string) (RSSFeedFactory, string, error) {
+	if feedType == "" {
+		feedType = guessFeedType(source)
+	}
+	newFeed, ok := feedTypes[feedType]
+	if !ok {
+		names := make([]string, 0, len(feedTypes))
+		for name := range feedTypes {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		return nil, "", fmt.Errorf("err: can't tell the type of %v, use -type with one of %v", source, strings.Join(names, ", "))
+	}
+	return newFeed, feedType, nil
+}
+
+func readFeedFile(path string, newFeed RSSFeedFactory) (RSSFeed, error) {
+	pageData, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	feed, err := unmarshalFeed(pageData, newFeed)
+	if err != nil {
+		return nil, fmt.Errorf("%v: %v", path, err)
+	}
+	return feed, nil
+}
+
+func testFeed(args []string) int {
+	flags := flag.NewFlagSet("test-feed", flag.ContinueOnError)
+	feedType := flags.String("type", "", "the feed's parser, guessed from the URL or directory name if not given")
+	asJson := flags.Bool("json", false, "print the items as JSON")
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+	if flags.NArg() != 1 {
+		fmt.Fprintln(os.Stderr, "usage: test-feed [-type t] [-json] <url|file>")
+		return 2
+	}
+	source := flags.Arg(0)
+
+	newFeed, _, err := feedTypeFor(*feedType, source)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	var feed RSSFeed
+	if strings.HasPrefix(source, "http://") || strings.HasPrefix(source, "https://") {
+		feed, err = fetchFeed(context.Background(), source, newFeed)
+	} else {
+		feed, err = readFeedFile(source, newFeed)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	items := feed.messageData()
+	if *asJson {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		if err = encoder.Encode(items); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		return 0
+	}
+	for _, item := range items {
+		fmt.Println(describeItem(item))
+	}
+	fmt.Printf("%d item(s)\n", len(items))
+	return 0
+}
+
+func startOffline(statePath string) error {
+	/*
+		Set up the pipeline to print to stdout and keep state in memory, so nothing reaches Discord or the disk
+	*/
+	var err error
+	if store, err = loadStateStore(statePath); err != nil {
+		return err
+	}
+	store.readOnly = true
+	discord = newPrintingClient(os.Stdout)
+	auditFile = os.DevNull
+	auditChannelId = ""
+	return nil
+}
+
+func dryRun(cfg botConfig, args []string) int {
+	flags := flag.NewFlagSet("dry-run", flag.ContinueOnError)
+	onlyFeed := flags.String("feed", "", "only poll this feed URL")
+	interval := flags.Duration("interval", cfg.PollInterval, "how often to poll each feed")
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+
+	if err := startOffline(cfg.StateFile); err != nil {
+		fmt.Fprintln(os.Stderr, "reading state file:", err)
+		return 1
+	}
+	staleAfter = cfg.StaleAfter
+	maxItemAge = cfg.MaxItemAge
+	store.seedFeeds(defaultFeeds)
+
+	var stop context.CancelFunc
+	botContext, stop = signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	scheduler = newFeedScheduler(botContext, *interval, cfg.MaxFetches)
+
+	started := 0
+	for _, feed := range store.listFeeds() {
+		if *onlyFeed != "" && feed.URL != *onlyFeed {
+			continue
+		}
+		if _, err := scheduler.add(feed); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			continue
+		}
+		started++
+	}
+	if started == 0 {
+		fmt.Fprintln(os.Stderr, "no feeds to poll")
+		return 1
+	}
+
+	fmt.Printf("polling %d feed(s) every %v, the first poll of each only records a baseline. Ctrl-C to stop\n", started, *interval)
+	<-botContext.Done()
+	stop()
+	waitTimeout(&scheduler.running, time.Now().Add(cfg.ShutdownTimeout))
+	return 0
+}
+
+func replay(cfg botConfig, args []string) int {
+	/*
+		Treat each file as the next snapshot of the feed, and show what would have been posted at each step. A
+		fixture directory is replayed as its old*.xml files followed by its new*.xml files. The items go through the
+		same filters as a live poll, so items older than MAX_ITEM_AGE_DAYS are skipped here too.
+	*/
+	flags := flag.NewFlagSet("replay", flag.ContinueOnError)
+	feedType := flags.String("type", "", "the feed's parser, guessed from the directory name if not given")
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+	if flags.NArg() < 1 {
+		fmt.Fprintln(os.Stderr, "usage: replay [-type t] <fixture dir | old.xml new.xml...>")
+		return 2
+	}
+
+	snapshots := flags.Args()
+	if info, err := os.Stat(snapshots[0]); err == nil && info.IsDir() && len(snapshots) == 1 {
+		dir := snapshots[0]
+		oldFiles, _ := filepath.Glob(filepath.Join(dir, "old*.xml"))
+		newFiles, _ := filepath.Glob(filepath.Join(dir, "new*.xml"))
+		snapshots = append(oldFiles, newFiles...)
+	}
+	if len(snapshots) < 2 {
+		fmt.Fprintln(os.Stderr, "replay needs at least two snapshots of the feed")
+		return 1
+	}
+
+	newFeed, resolvedType, err := feedTypeFor(*feedType, flags.Arg(0))
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	if err = startOffline(""); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	maxItemAge = cfg.MaxItemAge
+
+	previousName := filepath.Base(snapshots[0])
+	previous, err := readFeedFile(snapshots[0], newFeed)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	for _, snapshot := range snapshots[1:] {
+		current, err := readFeedFile(snapshot, newFeed)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		items, err := parseNewRssContent(context.Background(), previous, current)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "parsing %v: %v\n", snapshot, err)
+			return 1
+		}
+
+		fmt.Printf("=== %s → %s: %d item(s)\n", previousName, filepath.Base(snapshot), len(items))
+		for idx := range items {
+			items[idx].Source = resolvedType
+		}
+		// the snapshots stand in for the feed's URL, so it gets the default settings of a feed
+		postPollItems(context.Background(), flags.Arg(0), items)
+		previous, previousName = current, filepath.Base(snapshot)
+	}
+	return 0
+}
+
+func describeItem(item discordMessageData) string {
+	lines := []string{fmt.Sprintf("[%s] %s", item.itemKey(), item.Title), "    " + item.Link}
+	if len(item.Categories) > 0 {
+		lines = append(lines, "    categories: "+strings.Join(item.Categories, ", "))
+	}
+	if tags := classifyItem(item); len(tags) > 0 {
+		lines = append(lines, "    topics: "+strings.Join(tags, ", "))
+	}
+	if item.Description != "" {
+		lines = append(lines, "    "+truncate(strings.Join(strings.Fields(item.Description), " "), 300))
+	}
+	return strings.Join(lines, "\n")
+}
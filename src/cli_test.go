@@ -0,0 +1,148 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// captureStdout runs f with os.Stdout pointed at a pipe, returning its exit code and what it printed
+func captureStdout(t *testing.T, f func() int) (int, string) {
+	t.Helper()
+	reader, writer, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	oldStdout := os.Stdout
+	os.Stdout = writer
+	output := make(chan string)
+	go func() {
+		var buffer bytes.Buffer
+		io.Copy(&buffer, reader)
+		output <- buffer.String()
+	}()
+
+	code := f()
+	os.Stdout = oldStdout
+	writer.Close()
+	return code, <-output
+}
+
+// keepOfflineGlobals puts back the globals the offline subcommands set up for themselves
+func keepOfflineGlobals(t *testing.T) {
+	setupFakeDiscord(t)
+	oldContext, oldScheduler, oldAge, oldStale := botContext, scheduler, maxItemAge, staleAfter
+	t.Cleanup(func() {
+		botContext, scheduler, maxItemAge, staleAfter = oldContext, oldScheduler, oldAge, oldStale
+	})
+}
+
+func TestValidateConfig(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state.json")
+	st, err := loadStateStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = st.saveFeed(feedConfig{URL: testFeedUrl, Type: "zdi"}); err != nil {
+		t.Fatal(err)
+	}
+	cfg := botConfig{DiscordToken: "token", NewsChannelID: "news", StateFile: path}
+
+	code, output := captureStdout(t, func() int { return validateConfig(cfg, nil) })
+	if code != 0 || !strings.Contains(output, "configuration OK") || !strings.Contains(output, "ADMIN_CHANNEL_ID isn't set") {
+		t.Errorf("expected the config to pass with warnings, got %d: %s", code, output)
+	}
+
+	if err = st.saveFeed(feedConfig{URL: "https://example.com/other", Type: "gopher"}); err != nil {
+		t.Fatal(err)
+	}
+	cfg.DiscordToken = ""
+	code, output = captureStdout(t, func() int { return validateConfig(cfg, nil) })
+	if code != 1 || !strings.Contains(output, "DISCORD_BOT_TOKEN must be set") || !strings.Contains(output, "unknown type 'gopher'") {
+		t.Errorf("expected every problem to be reported, got %d: %s", code, output)
+	}
+}
+
+func TestTestFeed(t *testing.T) {
+	code, output := captureStdout(t, func() int { return testFeed([]string{"../rss_tests/zdi/newfeed.xml"}) })
+	if code != 0 || !strings.Contains(output, "Blog Post 3") || !strings.Contains(output, "item(s)") {
+		t.Errorf("expected the saved feed's items, got %d: %s", code, output)
+	}
+
+	code, output = captureStdout(t, func() int { return testFeed([]string{"-json", "../rss_tests/zdi/newfeed.xml"}) })
+	var items []discordMessageData
+	if err := json.Unmarshal([]byte(output), &items); code != 0 || err != nil || len(items) == 0 {
+		t.Errorf("expected the items as JSON, got %d, %v: %s", code, err, output)
+	}
+
+	outlet := startTestOutlet(t)
+	if code, output = captureStdout(t, func() int { return testFeed([]string{"-type", "zdi", outlet.URL + "/feed"}) }); code != 0 {
+		t.Errorf("expected the fetched feed's items, got %d: %s", code, output)
+	}
+	if code, _ = captureStdout(t, func() int { return testFeed([]string{outlet.URL + "/feed"}) }); code != 1 {
+		t.Errorf("expected a feed of unknown type to be refused, got %d", code)
+	}
+}
+
+func TestReplayFiltersLikeAPoll(t *testing.T) {
+	keepOfflineGlobals(t)
+	code, output := captureStdout(t, func() int { return replay(botConfig{}, []string{"../rss_tests/zdi"}) })
+	if code != 0 || !strings.Contains(output, "newfeed.xml: 1 item(s)") || !strings.Contains(output, "--- post") {
+		t.Errorf("expected the new item to be posted with the age check off, got %d: %s", code, output)
+	}
+
+	// the fixture is from 2023, so a live poll would skip it as too old
+	keepOfflineGlobals(t)
+	code, output = captureStdout(t, func() int {
+		return replay(botConfig{MaxItemAge: 30 * 24 * time.Hour}, []string{"../rss_tests/zdi"})
+	})
+	if code != 0 || strings.Contains(output, "--- post") {
+		t.Errorf("expected the old item to be skipped, got %d: %s", code, output)
+	}
+
+	if code, _ = captureStdout(t, func() int { return replay(botConfig{}, []string{"../rss_tests/zdi/newfeed.xml"}) }); code != 1 {
+		t.Errorf("expected one snapshot to be refused, got %d", code)
+	}
+}
+
+func TestDryRun(t *testing.T) {
+	keepOfflineGlobals(t)
+	outlet := startTestOutlet(t)
+	feedUrl := outlet.URL + "/feed"
+	path := filepath.Join(t.TempDir(), "state.json")
+	st, err := loadStateStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = st.saveFeed(feedConfig{URL: feedUrl, Type: "zdi"}); err != nil {
+		t.Fatal(err)
+	}
+	before, _ := os.ReadFile(path)
+
+	cfg := botConfig{StateFile: path, PollInterval: time.Hour, MaxFetches: 1, ShutdownTimeout: 5 * time.Second}
+	code, output := captureStdout(t, func() int {
+		go func() {
+			// the baseline and one more poll, then Ctrl-C
+			for polls := 0; polls < 2; polls++ {
+				select {
+				case <-outlet.arrived:
+				case <-time.After(5 * time.Second):
+				}
+			}
+			syscall.Kill(os.Getpid(), syscall.SIGINT)
+		}()
+		return dryRun(cfg, []string{"-feed", feedUrl, "-interval", "50ms"})
+	})
+	if code != 0 || !strings.Contains(output, "polling 1 feed(s) every 50ms") {
+		t.Errorf("unexpected dry run, got %d: %s", code, output)
+	}
+	if after, _ := os.ReadFile(path); !bytes.Equal(before, after) {
+		t.Error("dry run wrote to the state file")
+	}
+}
@@ -0,0 +1,136 @@
+/*
+The bot's configuration, read from environment variables. Every problem is collected rather than stopping at the first,
+so validate-config can report them all at once.
+*/
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+)
+
+type botConfig struct {
+	DiscordToken       string
+	NewsChannelID      string
+	AdminChannelID     string
+	ServerID           string
+	StateFile          string
+	AuditFile          string
+	AuditChannelID     string
+	HTTPAddr           string
+	LogLevel           string
+	LogFormat          string
+	PollInterval       time.Duration
+	StaleAfter         time.Duration
+	MaxItemAge         time.Duration
+	MaxFetches         int
+	ShutdownTimeout    time.Duration
+	DeregisterCommands bool
+}
+
+func configFromEnv() (cfg botConfig, problems []error) {
+	cfg = botConfig{
+		DiscordToken:       os.Getenv("DISCORD_BOT_TOKEN"),
+		NewsChannelID:      os.Getenv("DISCORD_CHANNEL_ID"),
+		AdminChannelID:     os.Getenv("ADMIN_CHANNEL_ID"),
+		ServerID:           os.Getenv("DISCORD_SERVER_ID"),
+		StateFile:          os.Getenv("STATE_FILE"),
+		AuditFile:          os.Getenv("AUDIT_FILE"),
+		AuditChannelID:     os.Getenv("AUDIT_CHANNEL_ID"),
+		HTTPAddr:           os.Getenv("HTTP_ADDR"),
+		LogLevel:           os.Getenv("LOG_LEVEL"),
+		LogFormat:          os.Getenv("LOG_FORMAT"),
+		PollInterval:       pollFreq * time.Minute,
+		StaleAfter:         staleAfter,
+		MaxItemAge:         maxItemAge,
+		MaxFetches:         defaultMaxConcurrentFetches,
+		ShutdownTimeout:    defaultShutdownTimeout,
+		DeregisterCommands: os.Getenv("DEREGISTER_COMMANDS") == "true",
+	}
+	if cfg.StateFile == "" {
+		cfg.StateFile = defaultStateFile
+	}
+	if cfg.AuditFile == "" {
+		cfg.AuditFile = defaultAuditFile
+	}
+	if cfg.HTTPAddr == "" {
+		cfg.HTTPAddr = defaultHTTPAddr
+	}
+
+	if interval := os.Getenv("POLL_INTERVAL"); interval != "" {
+		if pollInterval, err := time.ParseDuration(interval); err != nil || pollInterval <= 0 {
+			problems = append(problems, fmt.Errorf("err: POLL_INTERVAL must be a duration like 10m, not '%v'", interval))
+		} else {
+			cfg.PollInterval = pollInterval
+		}
+	}
+	if days := os.Getenv("STALE_AFTER_DAYS"); days != "" {
+		if staleDays, err := strconv.Atoi(days); err != nil || staleDays < 0 {
+			problems = append(problems, fmt.Errorf("err: STALE_AFTER_DAYS must be a whole number of days, not '%v'", days))
+		} else {
+			cfg.StaleAfter = time.Duration(staleDays) * 24 * time.Hour
+		}
+	}
+	if days := os.Getenv("MAX_ITEM_AGE_DAYS"); days != "" {
+		if ageDays, err := strconv.Atoi(days); err != nil || ageDays < 0 {
+			problems = append(problems, fmt.Errorf("err: MAX_ITEM_AGE_DAYS must be a whole number of days, not '%v'", days))
+		} else {
+			cfg.MaxItemAge = time.Duration(ageDays) * 24 * time.Hour
+		}
+	}
+	if fetches := os.Getenv("MAX_CONCURRENT_FETCHES"); fetches != "" {
+		if maxFetches, err := strconv.Atoi(fetches); err != nil || maxFetches < 1 {
+			problems = append(problems, fmt.Errorf("err: MAX_CONCURRENT_FETCHES must be a positive whole number, not '%v'", fetches))
+		} else {
+			cfg.MaxFetches = maxFetches
+		}
+	}
+	if timeout := os.Getenv("SHUTDOWN_TIMEOUT"); timeout != "" {
+		if shutdownTimeout, err := time.ParseDuration(timeout); err != nil {
+			problems = append(problems, fmt.Errorf("err: SHUTDOWN_TIMEOUT must be a duration like 30s, not '%v'", timeout))
+		} else {
+			cfg.ShutdownTimeout = shutdownTimeout
+		}
+	}
+	return
+}
+
+func (cfg botConfig) discordProblems() (problems []error) {
+	/*
+		Settings only needed when actually connecting to Discord, so the offline subcommands can skip them
+	*/
+	if cfg.DiscordToken == "" {
+		problems = append(problems, errors.New("err: DISCORD_BOT_TOKEN must be set"))
+	}
+	if cfg.NewsChannelID == "" {
+		problems = append(problems, errors.New("err: DISCORD_CHANNEL_ID must be set"))
+	}
+	return
+}
+
+func (cfg botConfig) discordWarnings() (warnings []string) {
+	if cfg.AdminChannelID == "" {
+		warnings = append(warnings, "ADMIN_CHANNEL_ID isn't set, so admin commands and alerts won't work")
+	}
+	if cfg.ServerID == "" {
+		warnings = append(warnings, "DISCORD_SERVER_ID isn't set, so the slash commands will be registered globally")
+	}
+	return
+}
+
+func (cfg botConfig) apply() {
+	/*
+		Copy the configuration into the globals the rest of the bot reads
+	*/
+	discordToken = cfg.DiscordToken
+	newsChannelId = cfg.NewsChannelID
+	adminChannelId = cfg.AdminChannelID
+	serverId = cfg.ServerID
+	auditFile = cfg.AuditFile
+	auditChannelId = cfg.AuditChannelID
+	staleAfter = cfg.StaleAfter
+	maxItemAge = cfg.MaxItemAge
+}
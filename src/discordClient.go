@@ -0,0 +1,163 @@
+/*
+The narrow slice of Discord the posting and command logic needs. The bot talks to Discord through sessionClient, the
+offline subcommands print what would have been sent with printingClient, and the tests record it with a fake.
+*/
+package main
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"sync/atomic"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+type discordClient interface {
+	// botUserID is the bot's own user, so it can ignore its own messages
+	botUserID() string
+	sendMessage(channelID string, message *discordgo.MessageSend) (*discordgo.Message, error)
+	editEmbed(channelID string, messageID string, embed *discordgo.MessageEmbed) error
+	deleteMessage(channelID string, messageID string) error
+	sendDirectMessage(userID string, content string) error
+	respond(interaction *discordgo.Interaction, response *discordgo.InteractionResponse) error
+	editResponse(interaction *discordgo.Interaction, edit *discordgo.WebhookEdit) error
+	guildRoles(guildID string) ([]*discordgo.Role, error)
+	// messagePermissions are the author's permissions in the channel the message was sent to
+	messagePermissions(message *discordgo.Message) (int64, error)
+}
+
+// the client everything posts through, a sessionClient once the bot has connected
+var discord discordClient
+
+// sessionClient is the real thing, backed by the gateway session
+type sessionClient struct {
+	session *discordgo.Session
+}
+
+func (c sessionClient) botUserID() string {
+	if c.session.State == nil || c.session.State.User == nil {
+		return ""
+	}
+	return c.session.State.User.ID
+}
+
+func (c sessionClient) sendMessage(channelID string, message *discordgo.MessageSend) (*discordgo.Message, error) {
+	return c.session.ChannelMessageSendComplex(channelID, message)
+}
+
+func (c sessionClient) editEmbed(channelID string, messageID string, embed *discordgo.MessageEmbed) error {
+	_, err := c.session.ChannelMessageEditEmbed(channelID, messageID, embed)
+	return err
+}
+
+func (c sessionClient) deleteMessage(channelID string, messageID string) error {
+	return c.session.ChannelMessageDelete(channelID, messageID)
+}
+
+func (c sessionClient) sendDirectMessage(userID string, content string) error {
+	channel, err := c.session.UserChannelCreate(userID)
+	if err != nil {
+		return err
+	}
+	_, err = c.session.ChannelMessageSend(channel.ID, content)
+	return err
+}
+
+func (c sessionClient) respond(interaction *discordgo.Interaction, response *discordgo.InteractionResponse) error {
+	return c.session.InteractionRespond(interaction, response)
+}
+
+func (c sessionClient) editResponse(interaction *discordgo.Interaction, edit *discordgo.WebhookEdit) error {
+	_, err := c.session.InteractionResponseEdit(interaction, edit)
+	return err
+}
+
+func (c sessionClient) guildRoles(guildID string) ([]*discordgo.Role, error) {
+	return c.session.GuildRoles(guildID)
+}
+
+func (c sessionClient) messagePermissions(message *discordgo.Message) (int64, error) {
+	return c.session.State.MessagePermissions(message)
+}
+
+// printingClient writes messages out instead of sending them, for dry-run and replay
+type printingClient struct {
+	out   io.Writer
+	sends *atomic.Int64
+}
+
+func newPrintingClient(out io.Writer) printingClient {
+	return printingClient{out: out, sends: new(atomic.Int64)}
+}
+
+func (c printingClient) botUserID() string {
+	return ""
+}
+
+func (c printingClient) sendMessage(channelID string, message *discordgo.MessageSend) (*discordgo.Message, error) {
+	sent := &discordgo.Message{ID: fmt.Sprintf("dry-run-%d", c.sends.Add(1)), ChannelID: channelID, Content: message.Content}
+	if channelID != "" {
+		fmt.Fprintf(c.out, "--- post %s to %s\n", sent.ID, channelID)
+	} else {
+		fmt.Fprintf(c.out, "--- post %s\n", sent.ID)
+	}
+	if message.Content != "" {
+		fmt.Fprintln(c.out, message.Content)
+	}
+	for _, embed := range message.Embeds {
+		fmt.Fprintln(c.out, describeEmbed(embed))
+	}
+	return sent, nil
+}
+
+func (c printingClient) editEmbed(channelID string, messageID string, embed *discordgo.MessageEmbed) error {
+	fmt.Fprintf(c.out, "--- edit %s\n%s\n", messageID, describeEmbed(embed))
+	return nil
+}
+
+func (c printingClient) deleteMessage(channelID string, messageID string) error {
+	fmt.Fprintf(c.out, "--- delete %s\n", messageID)
+	return nil
+}
+
+func (c printingClient) sendDirectMessage(userID string, content string) error {
+	fmt.Fprintf(c.out, "--- message to %s\n%s\n", userID, content)
+	return nil
+}
+
+func (c printingClient) respond(interaction *discordgo.Interaction, response *discordgo.InteractionResponse) error {
+	if response.Data != nil {
+		fmt.Fprintf(c.out, "--- response\n%s\n", response.Data.Content)
+	}
+	return nil
+}
+
+func (c printingClient) editResponse(interaction *discordgo.Interaction, edit *discordgo.WebhookEdit) error {
+	if edit.Content != nil {
+		fmt.Fprintf(c.out, "--- response\n%s\n", *edit.Content)
+	}
+	return nil
+}
+
+func (c printingClient) guildRoles(guildID string) ([]*discordgo.Role, error) {
+	return nil, nil
+}
+
+func (c printingClient) messagePermissions(message *discordgo.Message) (int64, error) {
+	return 0, nil
+}
+
+func describeEmbed(embed *discordgo.MessageEmbed) string {
+	lines := []string{embed.Title}
+	if embed.Description != "" {
+		lines = append(lines, embed.Description)
+	}
+	for _, field := range embed.Fields {
+		lines = append(lines, fmt.Sprintf("%s: %s", field.Name, field.Value))
+	}
+	if embed.Image != nil {
+		lines = append(lines, "image: "+embed.Image.URL)
+	}
+	return strings.Join(lines, "\n")
+}
@@ -1,17 +1,59 @@
 package main
 
 import (
+	"errors"
 	"fmt"
-	"log"
+	"log/slog"
+	"net/url"
+	"sort"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/bwmarrin/discordgo"
 )
 
+// separates the parts of a message component's custom ID, the first part selects the handler
+const customIdSep = "|"
+
+// a /send preview nobody has confirmed or cancelled in this long is forgotten
+const pendingSendTTL = time.Hour
+
+type pendingSend struct {
+	Item      discordMessageData
+	CreatedAt time.Time
+}
+
+// the only kinds of link people may submit to the news channel
+var allowedLinkSchemes = map[string]bool{"http": true, "https": true}
+
 type discordMessageData struct {
+	ID          string // identity of the item within its feed, e.g. the RSS guid
 	Title       string
 	Description string
 	Link        string
+	Image       string    // preview image for the embed, if one is known
+	Source      string    // the feed type the item came from, or "admin" for submitted links
+	Categories  []string  // tags the outlet gave the item, where it provides any
+	Tags        []string  // topic tags from classifyItem, see topics.go
+	Published   time.Time // when the outlet published the item, zero if the feed doesn't say
+	Modified    time.Time // when the outlet last changed the item, zero if the feed doesn't say
+	ContentHash string    // hash of the article's full text, for feeds that carry it, see hashContent
+	Updated     bool      // the item was seen before, but the outlet has since changed it
+	Notice      bool      // post an "Updated:" notice as well as editing the earlier message, see updates.go
+}
+
+func (item discordMessageData) itemKey() string {
+	/*
+		Not every feed provides a guid, so fall back to the link, and failing that the title
+	*/
+	if item.ID != "" {
+		return item.ID
+	}
+	if item.Link != "" {
+		return item.Link
+	}
+	return item.Title
 }
 
 var (
@@ -41,26 +83,111 @@ var (
 				},
 			},
 		},
+		{
+			Name:        "retract",
+			Description: "Remove a previously posted article from the news channel",
+
+			Options: []*discordgo.ApplicationCommandOption{
+				{
+					Type:        discordgo.ApplicationCommandOptionString,
+					Name:        "link",
+					Description: "The link of the article to retract",
+					Required:    true,
+				},
+			},
+		},
+		{
+			Name:        "amend",
+			Description: "Change the title or description of a previously posted article",
+
+			Options: []*discordgo.ApplicationCommandOption{
+				{
+					Type:        discordgo.ApplicationCommandOptionString,
+					Name:        "link",
+					Description: "The link of the article to amend",
+					Required:    true,
+				},
+				{
+					Type:        discordgo.ApplicationCommandOptionString,
+					Name:        "title",
+					Description: "The new title of the article",
+					Required:    false,
+				},
+				{
+					Type:        discordgo.ApplicationCommandOptionString,
+					Name:        "description",
+					Description: "The new description of the article",
+					Required:    false,
+				},
+			},
+		},
+		feedCommand(),
+		newsCommand(),
+		suggestCommand,
+		permissionsCommand(),
+		auditCommand,
+		statusCommand,
+	}
+
+	commandHandlers = map[string]func(s discordClient, i *discordgo.InteractionCreate){
+		"send":        slashCommandHandler,
+		"retract":     retractCommandHandler,
+		"amend":       amendCommandHandler,
+		"feed":        feedCommandHandler,
+		"news":        newsCommandHandler,
+		"suggest":     suggestCommandHandler,
+		"permissions": permissionsCommandHandler,
+		"audit":       auditCommandHandler,
+		"status":      statusCommandHandler,
 	}
 
-	commandHandlers = map[string]func(s *discordgo.Session, i *discordgo.InteractionCreate){
-		"send": slashCommandHandler,
+	// /send previews waiting for the admin to confirm them, keyed by the interaction ID. Not worth persisting,
+	// after a restart the admin can just use /send again
+	pendingSendsMu sync.Mutex
+	pendingSends   = map[string]pendingSend{}
+
+	// message components (buttons etc.) and modals are routed on the first part of their custom ID
+	componentHandlers = map[string]func(s discordClient, i *discordgo.InteractionCreate){
+		"burst":   burstComponentHandler,
+		"news":    newsComponentHandler,
+		"send":    sendComponentHandler,
+		"suggest": suggestComponentHandler,
 	}
 )
 
+func interactionHandler(s discordClient, i *discordgo.InteractionCreate) {
+	switch i.Type {
+	case discordgo.InteractionApplicationCommand:
+		if h, ok := commandHandlers[i.ApplicationCommandData().Name]; ok {
+			h(s, i)
+		}
+	case discordgo.InteractionMessageComponent:
+		prefix, _, _ := strings.Cut(i.MessageComponentData().CustomID, customIdSep)
+		if h, ok := componentHandlers[prefix]; ok {
+			h(s, i)
+		}
+	case discordgo.InteractionModalSubmit:
+		prefix, _, _ := strings.Cut(i.ModalSubmitData().CustomID, customIdSep)
+		if h, ok := componentHandlers[prefix]; ok {
+			h(s, i)
+		}
+	}
+}
+
 func hasAdminRole(userRoles []string) bool {
 	/*
-		Checks if the user has the committee or prior committee role
+		Checks if the user has the committee or prior committee role. These roles are optional, and only count
+		for capabilities that haven't been granted to anyone with /permissions.
 	*/
 	for _, role := range userRoles {
-		if role == committeeRoleID || role == priorCommitteeRoleID {
+		if role != "" && (role == committeeRoleID || role == priorCommitteeRoleID) {
 			return true
 		}
 	}
 	return false
 }
 
-func slashCommandHandler(s *discordgo.Session, i *discordgo.InteractionCreate) {
+func slashCommandHandler(s discordClient, i *discordgo.InteractionCreate) {
 	/*
 		Allow an admin to send links to the news channel. This is a lot better than the previous method !send
 		feature, because slash commands move the splitting of data to Discord, so we don't need to create logic for
@@ -71,123 +198,630 @@ func slashCommandHandler(s *discordgo.Session, i *discordgo.InteractionCreate) {
 			Title:       "Admin submitted article",
 			Description: "",
 			Link:        "",
+			Source:      "admin",
 		}
-		err error
 	)
 
-	if i.ID == s.State.User.ID {
+	if !checkInteractionCapability(s, i, capSubmit) {
 		return
 	}
-	if i.ChannelID != adminChannelId {
-		log.Println("Not the right channel")
-		if s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
-			Type: discordgo.InteractionResponseChannelMessageWithSource,
-			Data: &discordgo.InteractionResponseData{
-				Content: fmt.Sprintf("Please only use this command in <#%s>", adminChannelId),
+
+	// retrieve the options from the slash command, thankfully Discord does the parsing for us
+	optionMap := interactionOptions(i)
+
+	// this is a required option, so we can assume it exists
+	link, err := validateSubmissionLink(optionMap["link"].StringValue())
+	if err != nil {
+		interactionRespond(s, i, err.Error())
+		return
+	}
+	if post, ok := store.findPostByLink(link); ok && !post.Retracted {
+		interactionRespond(s, i, fmt.Sprintf("%s has already been posted", link))
+		return
+	}
+	messageData.Link = link
+	messageData.ID = link
+
+	titleOpt, hasTitle := optionMap["title"]
+	if hasTitle {
+		messageData.Title = "Admin submitted article: " + titleOpt.StringValue()
+	}
+	descriptionOpt, hasDescription := optionMap["description"]
+	if hasDescription {
+		messageData.Description = descriptionOpt.StringValue()
+	}
+
+	if hasTitle && hasDescription {
+		// uses the same function as the RSS feed to send the message, making a nice rich text embed
+		messageIDs, err := submitLink(messageData)
+		if err != nil {
+			auditInteraction(i, err.Error())
+			interactionRespond(s, i, err.Error())
+			return
+		}
+		auditInteraction(i, "posted", messageIDs...)
+		interactionRespond(s, i, fmt.Sprintf("Recieved link: %s, title: %s, description: %s", messageData.Link, messageData.Title, messageData.Description))
+		return
+	}
+
+	// fill in whatever the admin left out from the page itself, and let them check it before it goes out
+	interactionDefer(s, i)
+	meta, err := fetchPageMetadata(messageData.Link)
+	if err != nil {
+		slog.Warn("reading page metadata", "link", messageData.Link, "err", err)
+	}
+	if !hasTitle && meta.Title != "" {
+		messageData.Title = "Admin submitted article: " + meta.Title
+	}
+	if !hasDescription {
+		messageData.Description = meta.Description
+	}
+	messageData.Image = meta.Image
+
+	holdPendingSend(i.ID, messageData)
+
+	content := "Preview of the article, confirm to post it to the news channel"
+	if err != nil {
+		content = fmt.Sprintf("Couldn't read the page (%v), confirm to post it anyway", err)
+	}
+	customId := func(action string) string {
+		return strings.Join([]string{"send", action, i.ID}, customIdSep)
+	}
+	embeds := []*discordgo.MessageEmbed{newsEmbed(messageData)}
+	components := []discordgo.MessageComponent{
+		discordgo.ActionsRow{
+			Components: []discordgo.MessageComponent{
+				discordgo.Button{Label: "Confirm", Style: discordgo.SuccessButton, CustomID: customId("confirm")},
+				discordgo.Button{Label: "Cancel", Style: discordgo.SecondaryButton, CustomID: customId("cancel")},
 			},
-		}) != nil {
-			log.Printf("Interaction response failed: %v", err)
+		},
+	}
+	if err = s.editResponse(i.Interaction, &discordgo.WebhookEdit{
+		Content:    &content,
+		Embeds:     &embeds,
+		Components: &components,
+	}); err != nil {
+		slog.Warn("interaction response edit failed", "err", err)
+	}
+}
+
+func holdPendingSend(id string, item discordMessageData) {
+	/*
+		Keep a preview until its buttons are clicked, clearing out the ones nobody ever clicked while we're at it
+	*/
+	pendingSendsMu.Lock()
+	defer pendingSendsMu.Unlock()
+
+	for key, pending := range pendingSends {
+		if time.Since(pending.CreatedAt) > pendingSendTTL {
+			delete(pendingSends, key)
 		}
+	}
+	pendingSends[id] = pendingSend{Item: item, CreatedAt: time.Now()}
+}
+
+func takePendingSend(id string) (discordMessageData, bool) {
+	pendingSendsMu.Lock()
+	defer pendingSendsMu.Unlock()
+
+	pending, ok := pendingSends[id]
+	delete(pendingSends, id)
+	if !ok || time.Since(pending.CreatedAt) > pendingSendTTL {
+		return discordMessageData{}, false
+	}
+	return pending.Item, true
+}
+
+func sendComponentHandler(s discordClient, i *discordgo.InteractionCreate) {
+	/*
+		The confirm and cancel buttons under a /send preview
+	*/
+	parts := strings.Split(i.MessageComponentData().CustomID, customIdSep)
+	if len(parts) != 3 {
+		return
+	}
+	if !checkInteractionCapability(s, i, capSubmit) {
+		return
+	}
+
+	messageData, ok := takePendingSend(parts[2])
+
+	content := "Cancelled"
+	if !ok {
+		content = "This preview has expired, please use /send again"
+	} else if parts[1] == "confirm" {
+		content = "Posted to the news channel"
+		messageIDs, err := submitLink(messageData)
+		if err != nil {
+			content = err.Error()
+		}
+		auditInteraction(i, content, messageIDs...)
+	}
+
+	if err := s.respond(i.Interaction, &discordgo.InteractionResponse{
+		Type: discordgo.InteractionResponseUpdateMessage,
+		Data: &discordgo.InteractionResponseData{
+			Content:    content,
+			Components: []discordgo.MessageComponent{},
+		},
+	}); err != nil {
+		slog.Warn("interaction response failed", "err", err)
+	}
+}
+
+func retractCommandHandler(s discordClient, i *discordgo.InteractionCreate) {
+	/*
+		Delete an article the bot posted, for when an outlet withdraws a story or something was posted by mistake.
+		The post is kept in the store as retracted so the feed cannot post it again.
+	*/
+	if !checkInteractionCapability(s, i, capModerate) {
+		return
+	}
+
+	link := interactionOptions(i)["link"].StringValue()
+	post, ok := store.findPostByLink(link)
+	if !ok || post.Retracted {
+		interactionRespond(s, i, fmt.Sprintf("No posted article found for %s", link))
+		return
+	}
+
+	if err := s.deleteMessage(post.ChannelID, post.MessageID); err != nil {
+		slog.Error("deleting message", "message", post.MessageID, "err", err)
+		interactionRespond(s, i, fmt.Sprintf("Failed to delete the message for %s", link))
+		return
+	}
+
+	post.Retracted = true
+	post.EditedAt = time.Now()
+	if err := store.recordPost(post); err != nil {
+		slog.Error("saving state", "err", err)
+	}
+	auditInteraction(i, "retracted", post.MessageID)
+	interactionRespond(s, i, fmt.Sprintf("Retracted article: %s", post.Title))
+}
+
+func amendCommandHandler(s discordClient, i *discordgo.InteractionCreate) {
+	/*
+		Correct the title or description of an article the bot has already posted
+	*/
+	if !checkInteractionCapability(s, i, capModerate) {
+		return
+	}
+
+	optionMap := interactionOptions(i)
+	link := optionMap["link"].StringValue()
+	post, ok := store.findPostByLink(link)
+	if !ok || post.Retracted {
+		interactionRespond(s, i, fmt.Sprintf("No posted article found for %s", link))
+		return
+	}
+
+	item := discordMessageData{ID: post.ItemID, Title: post.Title, Description: post.Description, Link: post.Link, Image: post.Image, Categories: post.Categories, Published: post.Published}
+	if opt, ok := optionMap["title"]; ok {
+		item.Title = opt.StringValue()
+	}
+	if opt, ok := optionMap["description"]; ok {
+		item.Description = opt.StringValue()
+	}
+
+	if err := editPostedMessage(post, item); err != nil {
+		interactionRespond(s, i, fmt.Sprintf("Failed to amend the message for %s", link))
 		return
 	}
+	auditInteraction(i, "amended", post.MessageID)
+	interactionRespond(s, i, fmt.Sprintf("Amended article: %s", item.Title))
+}
+
+func checkInteractionCapability(s discordClient, i *discordgo.InteractionCreate, cap capability) bool {
+	/*
+		Admin commands may only be used in the admin channel, by a member with the capability the command needs.
+		Responds to the interaction explaining why when the check fails.
+	*/
+	if i.ID == s.botUserID() {
+		return false
+	}
+	if i.ChannelID != adminChannelId {
+		slog.Debug("admin command used outside the admin channel", "channel", i.ChannelID)
+		interactionRespond(s, i, fmt.Sprintf("Please only use this command in <#%s>", adminChannelId))
+		return false
+	}
 
 	// I guess this is actually redundant because the channel defined above is currently an admin-only channel,
 	// but I'll leave it in, in case we want to change the channel in the future.
-	if !hasAdminRole(i.Member.Roles) {
-		if s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
-			Type: discordgo.InteractionResponseChannelMessageWithSource,
-			Data: &discordgo.InteractionResponseData{
-				Content: fmt.Sprintf("You do not have the required role to use this command"),
-			},
-		}) != nil {
-			log.Printf("Interaction response failed: %v", err)
-		}
+	if i.Member == nil || !hasCapability(i.GuildID, i.Member, i.Member.Permissions, cap) {
+		interactionRespond(s, i, fmt.Sprintf("You do not have the '%s' permission needed to use this command", cap))
+		return false
 	}
+	return true
+}
 
-	// retrieve the options from the slash command, thankfully Discord does the parsing for us
-	options := i.ApplicationCommandData().Options
+func interactionOptions(i *discordgo.InteractionCreate) map[string]*discordgo.ApplicationCommandInteractionDataOption {
+	return optionsMap(i.ApplicationCommandData().Options)
+}
+
+func optionsMap(options []*discordgo.ApplicationCommandInteractionDataOption) map[string]*discordgo.ApplicationCommandInteractionDataOption {
 	optionMap := make(map[string]*discordgo.ApplicationCommandInteractionDataOption, len(options))
 	for _, opt := range options {
 		optionMap[opt.Name] = opt
 	}
+	return optionMap
+}
 
-	// this is a required option, so we can assume it exists
-	messageData.Link = optionMap["link"].StringValue()
+func interactionRespond(s discordClient, i *discordgo.InteractionCreate, content string) {
+	if err := s.respond(i.Interaction, &discordgo.InteractionResponse{
+		Type: discordgo.InteractionResponseChannelMessageWithSource,
+		Data: &discordgo.InteractionResponseData{
+			Content: content,
+		},
+	}); err != nil {
+		slog.Warn("interaction response failed", "err", err)
+	}
+}
+
+func interactionRespondEphemeral(s discordClient, i *discordgo.InteractionCreate, content string) {
+	/*
+		A response only the member who used the command can see
+	*/
+	if err := s.respond(i.Interaction, &discordgo.InteractionResponse{
+		Type: discordgo.InteractionResponseChannelMessageWithSource,
+		Data: &discordgo.InteractionResponseData{
+			Content: content,
+			Flags:   discordgo.MessageFlagsEphemeral,
+		},
+	}); err != nil {
+		slog.Warn("interaction response failed", "err", err)
+	}
+}
 
-	// not going to get too clever about trying to parse the title and description from the web page or anything
-	// just leave it up to the admin
-	if _, ok := optionMap["title"]; ok {
-		messageData.Title = "Admin submitted article: " + optionMap["title"].StringValue()
+func interactionRespondModal(s discordClient, i *discordgo.InteractionCreate, customId string, title string, inputs ...discordgo.TextInput) {
+	/*
+		Open a modal form, one text input per row. The submission comes back as an interaction with the given custom ID.
+	*/
+	rows := make([]discordgo.MessageComponent, 0, len(inputs))
+	for _, input := range inputs {
+		rows = append(rows, discordgo.ActionsRow{Components: []discordgo.MessageComponent{input}})
+	}
+	if err := s.respond(i.Interaction, &discordgo.InteractionResponse{
+		Type: discordgo.InteractionResponseModal,
+		Data: &discordgo.InteractionResponseData{
+			CustomID:   customId,
+			Title:      title,
+			Components: rows,
+		},
+	}); err != nil {
+		slog.Warn("interaction response failed", "err", err)
 	}
-	if _, ok := optionMap["description"]; ok {
-		messageData.Description = optionMap["description"].StringValue()
+}
+
+func modalValues(i *discordgo.InteractionCreate) map[string]string {
+	values := make(map[string]string)
+	for _, component := range i.ModalSubmitData().Components {
+		row, ok := component.(*discordgo.ActionsRow)
+		if !ok {
+			continue
+		}
+		for _, rowComponent := range row.Components {
+			if input, ok := rowComponent.(*discordgo.TextInput); ok {
+				values[input.CustomID] = input.Value
+			}
+		}
 	}
+	return values
+}
 
-	if s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
+func interactionRespondEmbeds(s discordClient, i *discordgo.InteractionCreate, embeds []*discordgo.MessageEmbed) {
+	if err := s.respond(i.Interaction, &discordgo.InteractionResponse{
 		Type: discordgo.InteractionResponseChannelMessageWithSource,
 		Data: &discordgo.InteractionResponseData{
-			Content: fmt.Sprintf("Recieved link: %s, title: %s, description: %s", messageData.Link, messageData.Title, messageData.Description),
+			Embeds: embeds,
 		},
-	}) != nil {
-		log.Printf("Interaction response failed: %v", err)
+	}); err != nil {
+		slog.Warn("interaction response failed", "err", err)
 	}
+}
 
-	// use the same function as the RSS feed to send the message, making a nice rich text embed
-	submitNewRssContent([]discordMessageData{{Title: messageData.Title, Description: messageData.Description, Link: messageData.Link}})
+func interactionDefer(s discordClient, i *discordgo.InteractionCreate) {
+	/*
+		Discord only waits three seconds for a response. Anything slower, like fetching a web page, has to acknowledge
+		the interaction first and fill in the response later with interactionEdit.
+	*/
+	if err := s.respond(i.Interaction, &discordgo.InteractionResponse{
+		Type: discordgo.InteractionResponseDeferredChannelMessageWithSource,
+	}); err != nil {
+		slog.Warn("interaction response failed", "err", err)
+	}
+}
+
+func interactionEdit(s discordClient, i *discordgo.InteractionCreate, content string) {
+	interactionEditEmbeds(s, i, content, nil)
+}
+
+func interactionEditEmbeds(s discordClient, i *discordgo.InteractionCreate, content string, embeds []*discordgo.MessageEmbed) {
+	edit := &discordgo.WebhookEdit{Content: &content}
+	if embeds != nil {
+		edit.Embeds = &embeds
+	}
+	if err := s.editResponse(i.Interaction, edit); err != nil {
+		slog.Warn("interaction response edit failed", "err", err)
+	}
 }
 
-func submitNewRssContent(newRssContent []discordMessageData) {
+func validateSubmissionLink(link string) (string, error) {
+	/*
+		Check a link submitted by a person is something we're happy to post: an absolute web URL, without
+		credentials in it. Returns the link in a normalised form, so the same article submitted twice is recognised.
+	*/
+	parsed, err := url.Parse(strings.TrimSpace(link))
+	if err != nil {
+		return "", fmt.Errorf("'%s' is not a valid link", link)
+	}
+	if !allowedLinkSchemes[strings.ToLower(parsed.Scheme)] || parsed.Host == "" {
+		return "", fmt.Errorf("'%s' is not a web link, please send a full http(s) URL", link)
+	}
+	if parsed.User != nil {
+		return "", errors.New("links containing usernames or passwords are not allowed")
+	}
+
+	parsed.Scheme = strings.ToLower(parsed.Scheme)
+	parsed.Host = strings.ToLower(parsed.Host)
+	parsed.Fragment = ""
+	return parsed.String(), nil
+}
+
+func submitLink(item discordMessageData) ([]string, error) {
+	/*
+		Post a link that a person submitted, rather than one found in a feed. Unlike feed items, a link that has
+		already been posted is refused rather than edited. Returns the ID of the message posted.
+	*/
+	if post, ok := store.findPostByLink(item.Link); ok && !post.Retracted {
+		return nil, fmt.Errorf("%s has already been posted", item.Link)
+	}
+	messageIDs := submitNewRssContent([]discordMessageData{item})
+	if len(messageIDs) == 0 {
+		return nil, errors.New("the article could not be posted, check the logs")
+	}
+	return messageIDs, nil
+}
+
+func newsEmbed(item discordMessageData) *discordgo.MessageEmbed {
+	embed := &discordgo.MessageEmbed{
+		Type:        discordgo.EmbedTypeRich,
+		Title:       truncate(item.Title, 256),
+		Description: truncate(item.Description, 4096),
+		Fields: []*discordgo.MessageEmbedField{
+			{
+				Name:   "Read it here",
+				Value:  item.Link,
+				Inline: true,
+			},
+		},
+	}
+	if item.Image != "" {
+		embed.Image = &discordgo.MessageEmbedImage{URL: item.Image}
+	}
+	if len(item.Tags) > 0 {
+		embed.Fields = append(embed.Fields, &discordgo.MessageEmbedField{
+			Name:   "Topics",
+			Value:  strings.Join(item.Tags, ", "),
+			Inline: true,
+		})
+	}
+	if !item.Published.IsZero() {
+		embed.Timestamp = item.Published.Format(time.RFC3339)
+	}
+	return embed
+}
+
+func submitNewRssContent(newRssContent []discordMessageData) (messageIDs []string) {
+	/*
+		Post new articles to the news channel, and edit the ones we've already posted. The items are in feed order,
+		newest first, and are posted oldest first so the channel reads in order. Returns the IDs of the messages
+		posted or edited.
+	*/
+	posts := beginPosting()
+	if posts == nil {
+		slog.Warn("shutting down, not posting new items", "items", len(newRssContent))
+		return
+	}
+	defer posts.Done()
+
+	newRssContent = oldestFirst(newRssContent)
+	metricQueueDepth.add(float64(len(newRssContent)))
 	for _, item := range newRssContent {
+		metricQueueDepth.add(-1)
+		if item.Tags == nil {
+			item.Tags = classifyItem(item)
+		}
+		auditArgs := map[string]string{"source": item.Source, "link": item.Link, "title": item.Title}
+		itemLog := slog.With("source", item.Source, "item", item.itemKey())
+
+		// anything we have posted before is edited in place rather than posted again
+		if post, ok := store.lookupPost(item.itemKey()); ok {
+			if post.Retracted || (!item.Modified.IsZero() && item.Modified.Before(post.Modified)) {
+				// an older version than the one posted, from a lagging mirror or cache
+				continue
+			}
+			changed := item.changes(post.Title, post.Description, post.ContentHash)
+			if len(changed) == 0 {
+				continue
+			}
+			itemLog.Info("editing message", "title", item.Title, "message", post.MessageID, "changed", changed)
+			if editPostedMessage(post, item) != nil {
+				continue
+			}
+			messageIDs = append(messageIDs, post.MessageID)
+			recordAudit(auditEntry{Command: "edit", Args: auditArgs, MessageIDs: []string{post.MessageID}})
+			if item.Notice {
+				if notice := postUpdateNotice(post, item, changed); notice != nil {
+					messageIDs = append(messageIDs, notice.ID)
+					recordAudit(auditEntry{Command: "update notice", Args: auditArgs, MessageIDs: []string{notice.ID}})
+				}
+			}
+			continue
+		}
+		if item.Updated {
+			// an edit to an article that was filtered out or predates the bot, nothing to update
+			continue
+		}
+
 		// content := fmt.Sprintf("New article from %s\n\n%s: %s\n", "The Hacker News", item.Title, item.Link)
 		embed := &discordgo.MessageSend{
-			Embeds: []*discordgo.MessageEmbed{{
-				Type:        discordgo.EmbedTypeRich,
-				Title:       item.Title,
-				Description: item.Description,
-				Fields: []*discordgo.MessageEmbedField{
-					{
-						Name:   "Read it here",
-						Value:  item.Link,
-						Inline: true,
-					},
-				},
-			}},
+			Embeds: []*discordgo.MessageEmbed{newsEmbed(item)},
+		}
+
+		itemLog.Info("sending message", "title", item.Title)
+		message := sendDiscordMessage(topicChannel(item.Tags), embed)
+		if message == nil {
+			continue
 		}
+		messageIDs = append(messageIDs, message.ID)
+		recordAudit(auditEntry{Command: "post", Args: auditArgs, MessageIDs: []string{message.ID}})
 
-		log.Println("Sending message:", item.Title)
-		sendDiscordMessage(discordSession, embed)
+		if err := store.recordPost(postedMessage{
+			ItemID:      item.itemKey(),
+			ChannelID:   message.ChannelID,
+			MessageID:   message.ID,
+			Title:       item.Title,
+			Description: item.Description,
+			Link:        item.Link,
+			Image:       item.Image,
+			Source:      item.Source,
+			Categories:  item.Categories,
+			Tags:        item.Tags,
+			CVEs:        extractCVEs(item.Title + " " + item.Description),
+			Published:   item.Published,
+			Modified:    item.Modified,
+			ContentHash: item.ContentHash,
+			PostedAt:    time.Now(),
+		}); err != nil {
+			itemLog.Error("saving state", "err", err)
+		}
 	}
+	return
+}
+
+func oldestFirst(items []discordMessageData) []discordMessageData {
+	/*
+		Reverse feed order, then put the dated items in date order among themselves. Items without a date stay where
+		the outlet listed them, which is the best guess there is.
+	*/
+	ordered := make([]discordMessageData, len(items))
+	for idx, item := range items {
+		ordered[len(items)-1-idx] = item
+	}
+
+	var (
+		datedIdx []int
+		dated    []discordMessageData
+	)
+	for idx, item := range ordered {
+		if !item.Published.IsZero() {
+			datedIdx = append(datedIdx, idx)
+			dated = append(dated, item)
+		}
+	}
+	sort.SliceStable(dated, func(a, b int) bool { return dated[a].Published.Before(dated[b].Published) })
+	for pos, idx := range datedIdx {
+		ordered[idx] = dated[pos]
+	}
+	return ordered
+}
+
+func editPostedMessage(post postedMessage, item discordMessageData) error {
+	/*
+		Replace the embed of a message the bot posted earlier, and remember the new content
+	*/
+	if item.Tags == nil {
+		item.Tags = classifyItem(item)
+	}
+	if err := discord.editEmbed(post.ChannelID, post.MessageID, newsEmbed(item)); err != nil {
+		slog.Error("editing message", "message", post.MessageID, "err", err)
+		return err
+	}
+
+	post.Title = item.Title
+	post.Description = item.Description
+	post.CVEs = extractCVEs(item.Title + " " + item.Description)
+	post.Tags = item.Tags
+	if item.ContentHash != "" {
+		post.ContentHash = item.ContentHash
+	}
+	if item.Modified.After(post.Modified) {
+		post.Modified = item.Modified
+	}
+	post.EditedAt = time.Now()
+	if err := store.recordPost(post); err != nil {
+		slog.Error("saving state", "err", err)
+	}
+	return nil
 }
 
 // DiscordMessageHandler monitor #disord-updates channel for commands
-func discordMessageHandler(s *discordgo.Session, m *discordgo.MessageCreate) {
-	if m.Author.ID == s.State.User.ID || m.ChannelID != adminChannelId || !hasAdminRole(m.Member.Roles) {
+func discordMessageHandler(s discordClient, m *discordgo.MessageCreate) {
+	if m.Author.ID == s.botUserID() || m.ChannelID != adminChannelId || m.Member == nil {
 		return
 	}
 
 	// Check if the message content starts with "!send"
 	content := strings.TrimSpace(m.Content)
-	if !strings.Contains(content, "!send") {
+	if content != "!send" && !strings.HasPrefix(content, "!send ") {
 		return
 	}
 
-	// extract the link from the message by splitting starting at https:// and ending at a space
-	link := "https://" + strings.Split(content, "https://")[1]
+	// members attached to message events don't carry their user or permissions, unlike interactions
+	member := *m.Member
+	member.User = m.Author
+	permissions, _ := s.messagePermissions(m.Message)
+	if !hasCapability(m.GuildID, &member, permissions, capSubmit) {
+		return
+	}
 
-	// Respond to the message with the link
-	response := fmt.Sprintf("Received link: %s", link)
-	if _, err := s.ChannelMessageSend(m.ChannelID, response); err != nil {
-		fmt.Println("Error sending message:", err)
+	// legacy form of /send, "!send <link> [title]". It goes through the same checks and embed as the slash command
+	reply := func(response string) {
+		if _, err := s.sendMessage(m.ChannelID, &discordgo.MessageSend{Content: response, Reference: m.Reference()}); err != nil {
+			slog.Warn("replying to !send", "err", err)
+		}
+	}
+	args := strings.Fields(strings.TrimPrefix(content, "!send"))
+	if len(args) == 0 {
+		reply("Usage: !send <link> [title]")
+		return
 	}
 
-	if _, err := s.ChannelMessageSend(newsChannelId, "Admin submitted article\n\n"+link); err != nil {
-		fmt.Println("Error sending message:", err)
+	link, err := validateSubmissionLink(args[0])
+	if err != nil {
+		reply(err.Error())
+		return
+	}
+	messageData := discordMessageData{ID: link, Title: "Admin submitted article", Link: link, Source: "admin"}
+	if len(args) > 1 {
+		messageData.Title += ": " + strings.Join(args[1:], " ")
+	}
+
+	entry := auditEntry{
+		UserID:   m.Author.ID,
+		Username: m.Author.Username,
+		Command:  "!send",
+		Args:     map[string]string{"link": link, "title": messageData.Title},
+	}
+	if entry.MessageIDs, err = submitLink(messageData); err != nil {
+		entry.Result = err.Error()
+		recordAudit(entry)
+		reply(err.Error())
+		return
 	}
+	entry.Result = "posted"
+	recordAudit(entry)
+	reply(fmt.Sprintf("Received link: %s", link))
 }
 
-func sendDiscordMessage(session *discordgo.Session, message *discordgo.MessageSend) {
-	log.Println("Session:", session)
-	if _, err := session.ChannelMessageSendComplex(newsChannelId, message); err != nil {
-		log.Println("err: Message failed to send - ", err)
+func sendDiscordMessage(channelID string, message *discordgo.MessageSend) *discordgo.Message {
+	sent, err := discord.sendMessage(channelID, message)
+	if err != nil {
+		slog.Error("message failed to send", "channel", channelID, "err", err)
+		metricDiscordSends.inc("failure")
+		return nil
 	}
+	metricDiscordSends.inc("success")
+	return sent
 }
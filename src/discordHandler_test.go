@@ -0,0 +1,419 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+	"unicode/utf8"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+func TestSubmitNewRssContentPostsAndRecords(t *testing.T) {
+	fake := setupFakeDiscord(t)
+
+	item := discordMessageData{ID: "item-1", Title: "A title", Description: "A description", Link: "https://example.com/1", Source: "test"}
+	messageIDs := submitNewRssContent([]discordMessageData{item})
+
+	sent := fake.sentTo(testNewsChannel)
+	if len(sent) != 1 || len(messageIDs) != 1 || messageIDs[0] != sent[0].ID {
+		t.Fatalf("expected one post to the news channel, got %d sent and IDs %v", len(sent), messageIDs)
+	}
+	if embed := sent[0].Message.Embeds[0]; embed.Title != item.Title || embed.Description != item.Description {
+		t.Errorf("posted embed %q / %q, want %q / %q", embed.Title, embed.Description, item.Title, item.Description)
+	}
+	post, ok := store.lookupPost(item.itemKey())
+	if !ok || post.MessageID != sent[0].ID || post.ChannelID != testNewsChannel {
+		t.Errorf("post not recorded against the sent message, got %+v", post)
+	}
+}
+
+func TestSubmitNewRssContentPostsOldestFirst(t *testing.T) {
+	fake := setupFakeDiscord(t)
+
+	// newest first, as a feed lists them, with an undated item that stays where it was listed
+	day := func(d int) time.Time { return time.Date(2024, 1, d, 12, 0, 0, 0, time.UTC) }
+	submitNewRssContent([]discordMessageData{
+		{ID: "3", Title: "Third", Link: "https://example.com/3", Published: day(3)},
+		{ID: "undated", Title: "Undated", Link: "https://example.com/undated"},
+		{ID: "1", Title: "First", Link: "https://example.com/1", Published: day(1)},
+		{ID: "2", Title: "Second", Link: "https://example.com/2", Published: day(2)},
+	})
+
+	var titles []string
+	for _, sent := range fake.sentTo(testNewsChannel) {
+		titles = append(titles, sent.Message.Embeds[0].Title)
+	}
+	if strings.Join(titles, ",") != "First,Second,Undated,Third" {
+		t.Errorf("posted in the order %v", titles)
+	}
+	if sent := fake.sentTo(testNewsChannel); sent[0].Message.Embeds[0].Timestamp != "2024-01-01T12:00:00Z" || sent[2].Message.Embeds[0].Timestamp != "" {
+		t.Errorf("embed timestamps should be the publish dates, got %q and %q", sent[0].Message.Embeds[0].Timestamp, sent[2].Message.Embeds[0].Timestamp)
+	}
+	if post, _ := store.lookupPost("1"); !post.Published.Equal(day(1)) {
+		t.Errorf("publish date not recorded, got %v", post.Published)
+	}
+}
+
+func TestSubmitNewRssContentEditsChangedItems(t *testing.T) {
+	fake := setupFakeDiscord(t)
+
+	item := discordMessageData{ID: "item-1", Title: "A title", Link: "https://example.com/1"}
+	submitNewRssContent([]discordMessageData{item})
+
+	// the same item again is left alone
+	submitNewRssContent([]discordMessageData{item})
+	if len(fake.sent) != 1 || len(fake.edits) != 0 {
+		t.Fatalf("unchanged item should not be posted or edited, got %d sent and %d edits", len(fake.sent), len(fake.edits))
+	}
+
+	item.Title = "A corrected title"
+	item.Updated = true
+	submitNewRssContent([]discordMessageData{item})
+	if len(fake.sent) != 1 || len(fake.edits) != 1 {
+		t.Fatalf("changed item should be edited in place, got %d sent and %d edits", len(fake.sent), len(fake.edits))
+	}
+	if fake.edits[0].MessageID != fake.sent[0].ID || fake.edits[0].Embed.Title != item.Title {
+		t.Errorf("edited %s to %q, want %s to %q", fake.edits[0].MessageID, fake.edits[0].Embed.Title, fake.sent[0].ID, item.Title)
+	}
+	if post, _ := store.lookupPost(item.itemKey()); post.Title != item.Title {
+		t.Errorf("stored title %q, want %q", post.Title, item.Title)
+	}
+}
+
+func TestSubmitNewRssContentSkipsUpdatesToUnpostedItems(t *testing.T) {
+	fake := setupFakeDiscord(t)
+
+	submitNewRssContent([]discordMessageData{{ID: "item-1", Title: "A title", Link: "https://example.com/1", Updated: true}})
+	if len(fake.sent) != 0 || len(fake.edits) != 0 {
+		t.Errorf("update to an item that was never posted should be skipped, got %d sent and %d edits", len(fake.sent), len(fake.edits))
+	}
+}
+
+func TestSubmitNewRssContentFailedSendNotRecorded(t *testing.T) {
+	fake := setupFakeDiscord(t)
+	fake.failing = true
+
+	item := discordMessageData{ID: "item-1", Title: "A title", Link: "https://example.com/1"}
+	if messageIDs := submitNewRssContent([]discordMessageData{item}); len(messageIDs) != 0 {
+		t.Errorf("expected no message IDs when sending fails, got %v", messageIDs)
+	}
+	if _, ok := store.lookupPost(item.itemKey()); ok {
+		t.Error("a post that failed to send was recorded, so it will never be retried")
+	}
+}
+
+func TestSendCommandPostsWithTitleAndDescription(t *testing.T) {
+	fake := setupFakeDiscord(t)
+
+	i := commandInteraction("send", testMember("admin-user", 0, "committee"),
+		stringOption("link", "https://example.com/article"), stringOption("title", "Title"), stringOption("description", "Description"))
+	interactionHandler(fake, i)
+
+	if response := fake.lastResponse(t); !strings.HasPrefix(response, "Recieved link: https://example.com/article") {
+		t.Errorf("unexpected response %q", response)
+	}
+	sent := fake.sentTo(testNewsChannel)
+	if len(sent) != 1 || sent[0].Message.Embeds[0].Title != "Admin submitted article: Title" {
+		t.Fatalf("expected the article to be posted, got %+v", sent)
+	}
+
+	// sending it again is refused
+	interactionHandler(fake, i)
+	if response := fake.lastResponse(t); response != "https://example.com/article has already been posted" {
+		t.Errorf("unexpected response to a duplicate %q", response)
+	}
+	if len(fake.sentTo(testNewsChannel)) != 1 {
+		t.Error("duplicate link was posted again")
+	}
+}
+
+func TestPendingSendsExpire(t *testing.T) {
+	defer func() { pendingSends = map[string]pendingSend{} }()
+	pendingSends = map[string]pendingSend{}
+
+	holdPendingSend("forgotten", discordMessageData{Link: "https://example.com/forgotten"})
+	holdPendingSend("stale", discordMessageData{Link: "https://example.com/stale"})
+	for _, id := range []string{"forgotten", "stale"} {
+		pending := pendingSends[id]
+		pending.CreatedAt = time.Now().Add(-pendingSendTTL - time.Minute)
+		pendingSends[id] = pending
+	}
+	if _, ok := takePendingSend("stale"); ok {
+		t.Error("an expired preview could still be confirmed")
+	}
+
+	// holding another preview sweeps out the ones nobody clicked
+	holdPendingSend("fresh", discordMessageData{Link: "https://example.com/fresh"})
+	if _, ok := pendingSends["forgotten"]; ok || len(pendingSends) != 1 {
+		t.Errorf("expected only the fresh preview to be kept, got %+v", pendingSends)
+	}
+	if item, ok := takePendingSend("fresh"); !ok || item.Link != "https://example.com/fresh" {
+		t.Errorf("fresh preview not returned, got %+v", item)
+	}
+}
+
+func TestCommandsOnlyInAdminChannel(t *testing.T) {
+	fake := setupFakeDiscord(t)
+
+	i := commandInteraction("send", testMember("admin-user", 0, "committee"),
+		stringOption("link", "https://example.com/article"), stringOption("title", "Title"), stringOption("description", "Description"))
+	i.ChannelID = "general"
+	interactionHandler(fake, i)
+
+	if response := fake.lastResponse(t); response != "Please only use this command in <#admin>" {
+		t.Errorf("unexpected response %q", response)
+	}
+	if len(fake.sent) != 0 {
+		t.Error("command used outside the admin channel posted anyway")
+	}
+}
+
+func TestCommandsNeedCapability(t *testing.T) {
+	fake := setupFakeDiscord(t)
+
+	i := commandInteraction("retract", testMember("member", 0, "someone"), stringOption("link", "https://example.com/article"))
+	interactionHandler(fake, i)
+
+	if response := fake.lastResponse(t); !strings.Contains(response, "'moderate' permission") {
+		t.Errorf("unexpected response %q", response)
+	}
+}
+
+func TestRetractAndAmend(t *testing.T) {
+	fake := setupFakeDiscord(t)
+	link := "https://example.com/article"
+	submitNewRssContent([]discordMessageData{{ID: "item-1", Title: "Original", Link: link}})
+	messageID := fake.sent[0].ID
+	moderator := testMember("moderator", discordgo.PermissionAdministrator)
+
+	interactionHandler(fake, commandInteraction("amend", moderator, stringOption("link", link), stringOption("title", "Amended")))
+	if response := fake.lastResponse(t); response != "Amended article: Amended" {
+		t.Errorf("unexpected response %q", response)
+	}
+	if len(fake.edits) != 1 || fake.edits[0].MessageID != messageID || fake.edits[0].Embed.Title != "Amended" {
+		t.Errorf("expected the posted message to be edited, got %+v", fake.edits)
+	}
+
+	interactionHandler(fake, commandInteraction("retract", moderator, stringOption("link", link)))
+	if response := fake.lastResponse(t); response != "Retracted article: Amended" {
+		t.Errorf("unexpected response %q", response)
+	}
+	if len(fake.deleted) != 1 || fake.deleted[0] != messageID {
+		t.Errorf("expected %s to be deleted, got %v", messageID, fake.deleted)
+	}
+	if post, _ := store.findPostByLink(link); !post.Retracted {
+		t.Error("retracted post not marked as retracted")
+	}
+
+	// a retracted item turning up in the feed again isn't reposted
+	submitNewRssContent([]discordMessageData{{ID: "item-1", Title: "Original again", Link: link}})
+	if len(fake.sentTo(testNewsChannel)) != 1 {
+		t.Error("retracted item was posted again")
+	}
+}
+
+func TestLegacySendMessage(t *testing.T) {
+	fake := setupFakeDiscord(t)
+	fake.permissions = discordgo.PermissionAdministrator
+
+	discordMessageHandler(fake, &discordgo.MessageCreate{Message: &discordgo.Message{
+		ID:        "command",
+		ChannelID: testAdminChannel,
+		GuildID:   testGuild,
+		Content:   "!send https://example.com/article Some title",
+		Author:    &discordgo.User{ID: "admin-user", Username: "admin-user"},
+		Member:    &discordgo.Member{},
+	}})
+
+	news := fake.sentTo(testNewsChannel)
+	if len(news) != 1 || news[0].Message.Embeds[0].Title != "Admin submitted article: Some title" {
+		t.Fatalf("expected the article to be posted, got %+v", news)
+	}
+	replies := fake.sentTo(testAdminChannel)
+	if len(replies) != 1 || replies[0].Message.Content != "Received link: https://example.com/article" {
+		t.Errorf("unexpected reply %+v", replies)
+	}
+}
+
+func TestLegacySendIgnoresBotAndUnprivileged(t *testing.T) {
+	fake := setupFakeDiscord(t)
+
+	for _, author := range []string{testBotUser, "member"} {
+		discordMessageHandler(fake, &discordgo.MessageCreate{Message: &discordgo.Message{
+			ChannelID: testAdminChannel,
+			GuildID:   testGuild,
+			Content:   "!send https://example.com/article",
+			Author:    &discordgo.User{ID: author},
+			Member:    &discordgo.Member{},
+		}})
+	}
+	if len(fake.sent) != 0 {
+		t.Errorf("expected nothing to be sent, got %+v", fake.sent)
+	}
+}
+
+func TestSuggestionApproved(t *testing.T) {
+	fake := setupFakeDiscord(t)
+
+	suggest := commandInteraction("suggest", testMember("member", 0), stringOption("link", "https://example.com/found"), stringOption("title", "Found it"))
+	suggest.ChannelID = "general"
+	interactionHandler(fake, suggest)
+	queued := fake.sentTo(testAdminChannel)
+	if len(queued) != 1 || len(fake.sentTo(testNewsChannel)) != 0 {
+		t.Fatalf("expected the suggestion to be queued for review only, got %+v", fake.sent)
+	}
+
+	interactionHandler(fake, componentInteraction("suggest"+customIdSep+"approve"+customIdSep+suggest.ID, testMember("moderator", 0, "committee")))
+	news := fake.sentTo(testNewsChannel)
+	if len(news) != 1 || news[0].Message.Embeds[0].Title != "Member submitted article: Found it" {
+		t.Fatalf("expected the approved suggestion to be posted, got %+v", news)
+	}
+	if sug, _ := store.lookupSuggestion(suggest.ID); sug.Status != suggestionApproved {
+		t.Errorf("suggestion status %q, want %q", sug.Status, suggestionApproved)
+	}
+	if dms := fake.directMessages["member"]; len(dms) != 1 || !strings.Contains(dms[0], "has been approved") {
+		t.Errorf("expected the member to be told, got %v", dms)
+	}
+}
+
+func TestSuggestionFailingToPostStaysPending(t *testing.T) {
+	fake := setupFakeDiscord(t)
+
+	suggest := commandInteraction("suggest", testMember("member", 0), stringOption("link", "https://example.com/found"))
+	suggest.ChannelID = "general"
+	interactionHandler(fake, suggest)
+	// a feed posts the same article before anyone reviews the suggestion
+	submitNewRssContent([]discordMessageData{{ID: "feed-item", Title: "From a feed", Link: "https://example.com/found"}})
+
+	interactionHandler(fake, componentInteraction("suggest"+customIdSep+"approve"+customIdSep+suggest.ID, testMember("moderator", 0, "committee")))
+	if response := fake.lastResponse(t); !strings.Contains(response, "still pending") {
+		t.Errorf("expected the moderator to be told it failed, got %q", response)
+	}
+	if sug, _ := store.lookupSuggestion(suggest.ID); sug.Status != suggestionPending {
+		t.Errorf("suggestion status %q, want %q", sug.Status, suggestionPending)
+	}
+	if dms := fake.directMessages["member"]; len(dms) != 0 {
+		t.Errorf("member told about a suggestion that wasn't posted, %v", dms)
+	}
+}
+
+func TestSuggestionApprovedOnceWhenModeratorsRace(t *testing.T) {
+	fake := setupFakeDiscord(t)
+
+	suggest := commandInteraction("suggest", testMember("member", 0), stringOption("link", "https://example.com/found"))
+	suggest.ChannelID = "general"
+	interactionHandler(fake, suggest)
+	approve := "suggest" + customIdSep + "approve" + customIdSep + suggest.ID
+
+	var wg sync.WaitGroup
+	for _, moderator := range []string{"first", "second", "third"} {
+		wg.Add(1)
+		go func(moderator string) {
+			defer wg.Done()
+			interactionHandler(fake, componentInteraction(approve, testMember(moderator, 0, "committee")))
+		}(moderator)
+	}
+	wg.Wait()
+	if news := fake.sentTo(testNewsChannel); len(news) != 1 {
+		t.Errorf("expected the suggestion to be posted once, got %d posts", len(news))
+	}
+	if sug, _ := store.lookupSuggestion(suggest.ID); sug.Status != suggestionApproved {
+		t.Errorf("suggestion status %q, want %q", sug.Status, suggestionApproved)
+	}
+}
+
+func TestSuggestionBeingApprovedCantBeTakenAgain(t *testing.T) {
+	fake := setupFakeDiscord(t)
+
+	suggest := commandInteraction("suggest", testMember("member", 0), stringOption("link", "https://example.com/found"))
+	suggest.ChannelID = "general"
+	interactionHandler(fake, suggest)
+	// another moderator is part way through posting it
+	if _, ok := store.claimSuggestion(suggest.ID, suggestionPending, suggestionApproving); !ok {
+		t.Fatal("couldn't claim the suggestion")
+	}
+
+	for _, action := range []string{"approve", "rejectreason"} {
+		interactionHandler(fake, componentInteraction("suggest"+customIdSep+action+customIdSep+suggest.ID, testMember("moderator", 0, "committee")))
+		if response := fake.lastResponse(t); !strings.Contains(response, "already been dealt with") {
+			t.Errorf("%s: unexpected response %q", action, response)
+		}
+	}
+	if len(fake.sentTo(testNewsChannel)) != 0 {
+		t.Error("a claimed suggestion was posted again")
+	}
+
+	// and a restart part way through posting puts it back up for review
+	reloaded, err := loadStateStore(store.path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sug, _ := reloaded.lookupSuggestion(suggest.ID); sug.Status != suggestionPending {
+		t.Errorf("suggestion status after a restart %q, want %q", sug.Status, suggestionPending)
+	}
+}
+
+var commandNamePattern = regexp.MustCompile(`^[-_\p{Ll}\p{N}]{1,32}$`)
+
+func checkCommandOptions(t *testing.T, path string, options []*discordgo.ApplicationCommandOption) int {
+	/*
+		Check options against the limits Discord enforces when commands are registered, returning how many
+		characters of names, descriptions and choices they add to the command
+	*/
+	t.Helper()
+	if len(options) > 25 {
+		t.Errorf("%s has %d options, more than 25", path, len(options))
+	}
+	length := 0
+	optional := false
+	for _, option := range options {
+		optionPath := path + " " + option.Name
+		if !commandNamePattern.MatchString(option.Name) {
+			t.Errorf("%s isn't a valid option name", optionPath)
+		}
+		if len(option.Description) == 0 || utf8.RuneCountInString(option.Description) > 100 {
+			t.Errorf("%s has a description of %d characters, it must be 1 to 100", optionPath, utf8.RuneCountInString(option.Description))
+		}
+		if option.Required && optional {
+			t.Errorf("%s is required but comes after an optional option", optionPath)
+		}
+		optional = optional || !option.Required
+		if len(option.Choices) > 25 {
+			t.Errorf("%s has %d choices, more than 25", optionPath, len(option.Choices))
+		}
+		for _, choice := range option.Choices {
+			if name := utf8.RuneCountInString(choice.Name); name == 0 || name > 100 {
+				t.Errorf("%s choice %q must be 1 to 100 characters", optionPath, choice.Name)
+			}
+			if value, ok := choice.Value.(string); ok && utf8.RuneCountInString(value) > 100 {
+				t.Errorf("%s choice value %q is longer than 100 characters", optionPath, value)
+			}
+			length += utf8.RuneCountInString(choice.Name) + len(fmt.Sprint(choice.Value))
+		}
+		length += utf8.RuneCountInString(option.Name) + utf8.RuneCountInString(option.Description)
+		length += checkCommandOptions(t, optionPath, option.Options)
+	}
+	return length
+}
+
+func TestCommandsWithinDiscordLimits(t *testing.T) {
+	if len(discordCommands) > 100 {
+		t.Errorf("%d commands, Discord allows 100", len(discordCommands))
+	}
+	for _, command := range discordCommands {
+		if !commandNamePattern.MatchString(command.Name) {
+			t.Errorf("/%s isn't a valid command name", command.Name)
+		}
+		if len(command.Description) == 0 || utf8.RuneCountInString(command.Description) > 100 {
+			t.Errorf("/%s has a description of %d characters, it must be 1 to 100", command.Name, utf8.RuneCountInString(command.Description))
+		}
+		length := utf8.RuneCountInString(command.Name) + utf8.RuneCountInString(command.Description)
+		if length += checkCommandOptions(t, "/"+command.Name, command.Options); length > 4000 {
+			t.Errorf("/%s comes to %d characters, more than 4000", command.Name, length)
+		}
+	}
+}
@@ -0,0 +1,202 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+const (
+	testGuild        = "guild"
+	testNewsChannel  = "news"
+	testAdminChannel = "admin"
+	testBotUser      = "bot"
+)
+
+type sentMessage struct {
+	ChannelID string
+	ID        string
+	Message   *discordgo.MessageSend
+}
+
+type editedEmbed struct {
+	ChannelID string
+	MessageID string
+	Embed     *discordgo.MessageEmbed
+}
+
+// recordingClient is an in-memory discordClient that records everything sent through it
+type recordingClient struct {
+	mu             sync.Mutex
+	nextID         int
+	sent           []sentMessage
+	edits          []editedEmbed
+	deleted        []string
+	directMessages map[string][]string
+	responses      []*discordgo.InteractionResponse
+	responseEdits  []*discordgo.WebhookEdit
+	roles          []*discordgo.Role
+	permissions    int64
+	// makes every send and edit fail, like Discord being unreachable
+	failing bool
+}
+
+var errFakeDiscord = errors.New("fake Discord is failing")
+
+func (c *recordingClient) botUserID() string {
+	return testBotUser
+}
+
+func (c *recordingClient) sendMessage(channelID string, message *discordgo.MessageSend) (*discordgo.Message, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.failing {
+		return nil, errFakeDiscord
+	}
+	c.nextID++
+	sent := sentMessage{ChannelID: channelID, ID: fmt.Sprintf("message-%d", c.nextID), Message: message}
+	c.sent = append(c.sent, sent)
+	return &discordgo.Message{ID: sent.ID, ChannelID: channelID, Content: message.Content}, nil
+}
+
+func (c *recordingClient) editEmbed(channelID string, messageID string, embed *discordgo.MessageEmbed) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.failing {
+		return errFakeDiscord
+	}
+	c.edits = append(c.edits, editedEmbed{ChannelID: channelID, MessageID: messageID, Embed: embed})
+	return nil
+}
+
+func (c *recordingClient) deleteMessage(channelID string, messageID string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.failing {
+		return errFakeDiscord
+	}
+	c.deleted = append(c.deleted, messageID)
+	return nil
+}
+
+func (c *recordingClient) sendDirectMessage(userID string, content string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.directMessages == nil {
+		c.directMessages = map[string][]string{}
+	}
+	c.directMessages[userID] = append(c.directMessages[userID], content)
+	return nil
+}
+
+func (c *recordingClient) respond(interaction *discordgo.Interaction, response *discordgo.InteractionResponse) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.responses = append(c.responses, response)
+	return nil
+}
+
+func (c *recordingClient) editResponse(interaction *discordgo.Interaction, edit *discordgo.WebhookEdit) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.responseEdits = append(c.responseEdits, edit)
+	return nil
+}
+
+func (c *recordingClient) guildRoles(guildID string) ([]*discordgo.Role, error) {
+	return c.roles, nil
+}
+
+func (c *recordingClient) messagePermissions(message *discordgo.Message) (int64, error) {
+	return c.permissions, nil
+}
+
+func (c *recordingClient) sentTo(channelID string) []sentMessage {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	var sent []sentMessage
+	for _, message := range c.sent {
+		if message.ChannelID == channelID {
+			sent = append(sent, message)
+		}
+	}
+	return sent
+}
+
+func (c *recordingClient) lastResponse(t *testing.T) string {
+	t.Helper()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if len(c.responses) == 0 || c.responses[len(c.responses)-1].Data == nil {
+		t.Fatal("no interaction response was sent")
+	}
+	return c.responses[len(c.responses)-1].Data.Content
+}
+
+func setupFakeDiscord(t *testing.T) *recordingClient {
+	/*
+		Point the bot's globals at a fake client and a fresh state and audit file, putting everything back after
+		the test
+	*/
+	t.Helper()
+	dir := t.TempDir()
+
+	oldStore, oldDiscord, oldAuditFile, oldAuditChannel := store, discord, auditFile, auditChannelId
+	oldNews, oldAdmin, oldCommittee, oldPrior := newsChannelId, adminChannelId, committeeRoleID, priorCommitteeRoleID
+	t.Cleanup(func() {
+		store, discord, auditFile, auditChannelId = oldStore, oldDiscord, oldAuditFile, oldAuditChannel
+		newsChannelId, adminChannelId, committeeRoleID, priorCommitteeRoleID = oldNews, oldAdmin, oldCommittee, oldPrior
+	})
+
+	var err error
+	if store, err = loadStateStore(filepath.Join(dir, "state.json")); err != nil {
+		t.Fatal(err)
+	}
+	fake := &recordingClient{}
+	discord = fake
+	auditFile = filepath.Join(dir, "audit.jsonl")
+	auditChannelId = ""
+	newsChannelId = testNewsChannel
+	adminChannelId = testAdminChannel
+	committeeRoleID, priorCommitteeRoleID = "committee", "prior-committee"
+	return fake
+}
+
+func testMember(userID string, permissions int64, roles ...string) *discordgo.Member {
+	return &discordgo.Member{User: &discordgo.User{ID: userID, Username: userID}, Permissions: permissions, Roles: roles}
+}
+
+func stringOption(name string, value string) *discordgo.ApplicationCommandInteractionDataOption {
+	return &discordgo.ApplicationCommandInteractionDataOption{Name: name, Type: discordgo.ApplicationCommandOptionString, Value: value}
+}
+
+func subcommandOption(name string, options ...*discordgo.ApplicationCommandInteractionDataOption) *discordgo.ApplicationCommandInteractionDataOption {
+	return &discordgo.ApplicationCommandInteractionDataOption{Name: name, Type: discordgo.ApplicationCommandOptionSubCommand, Options: options}
+}
+
+func commandInteraction(name string, member *discordgo.Member, options ...*discordgo.ApplicationCommandInteractionDataOption) *discordgo.InteractionCreate {
+	return &discordgo.InteractionCreate{Interaction: &discordgo.Interaction{
+		ID:        "interaction-" + name,
+		Type:      discordgo.InteractionApplicationCommand,
+		GuildID:   testGuild,
+		ChannelID: testAdminChannel,
+		Member:    member,
+		Data:      discordgo.ApplicationCommandInteractionData{Name: name, Options: options},
+	}}
+}
+
+func componentInteraction(customId string, member *discordgo.Member) *discordgo.InteractionCreate {
+	return &discordgo.InteractionCreate{Interaction: &discordgo.Interaction{
+		ID:        "interaction-" + customId,
+		Type:      discordgo.InteractionMessageComponent,
+		GuildID:   testGuild,
+		ChannelID: testAdminChannel,
+		Member:    member,
+		Message:   &discordgo.Message{ID: "component-message", ChannelID: testAdminChannel},
+		Data:      discordgo.MessageComponentInteractionData{CustomID: customId},
+	}}
+}
@@ -0,0 +1,615 @@
+/*
+Runtime management of the monitored feeds: one feedMonitor per feed, and the /feed admin command for adding, removing,
+pausing and inspecting them while the bot is running
+*/
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+// feedMonitor is one feed's job in the scheduler, and its state shared with the /feed commands
+type feedMonitor struct {
+	url      string
+	feedType string
+	newFeed  RSSFeedFactory
+	// cancelled when the feed is removed or the bot shuts down
+	ctx    context.Context
+	cancel context.CancelFunc
+	log    *slog.Logger
+	// poll straight away rather than waiting for the timer
+	pollNow chan struct{}
+
+	// the last good snapshot of the feed and its hash, only touched by poll
+	baseline     RSSFeed
+	baselineHash []byte
+
+	mu          sync.Mutex
+	paused      bool
+	running     bool
+	lastPoll    time.Time
+	lastSuccess time.Time
+	lastNewItem time.Time
+	failures    int
+	lastErr     error
+	lastFeed    RSSFeed
+}
+
+func (m *feedMonitor) recordPoll(feed RSSFeed, err error) {
+	/*
+		Called by poll after every poll. A nil feed means the poll failed and the last good snapshot is kept.
+	*/
+	defer checkFeedAlerts(m)
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.lastPoll = time.Now()
+	m.lastErr = err
+	if feed != nil {
+		m.lastFeed = feed
+	}
+
+	metricPolls.inc(m.url)
+	if err == nil {
+		m.lastSuccess = m.lastPoll
+		m.failures = 0
+		metricLastSuccessfulPoll.set(float64(m.lastPoll.Unix()), m.url)
+	} else {
+		m.failures++
+	}
+}
+
+func (m *feedMonitor) markStopped() {
+	defer checkFeedAlerts(m)
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.running = false
+}
+
+func (m *feedMonitor) markNewItems() {
+	m.mu.Lock()
+	m.lastNewItem = time.Now()
+	m.mu.Unlock()
+
+	if err := store.recordFeedItems(m.url, m.lastNewItem); err != nil {
+		m.log.Error("saving state", "err", err)
+	}
+}
+
+func (m *feedMonitor) isPaused() bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.paused
+}
+
+func (m *feedMonitor) setPaused(paused bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.paused = paused
+}
+
+func (m *feedMonitor) snapshot() (feedStatus feedMonitorStatus) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	feedStatus = feedMonitorStatus{
+		URL:         m.url,
+		Type:        m.feedType,
+		Paused:      m.paused,
+		Running:     m.running,
+		LastPoll:    m.lastPoll,
+		LastSuccess: m.lastSuccess,
+		LastNewItem: m.lastNewItem,
+		Failures:    m.failures,
+		LastErr:     m.lastErr,
+		Feed:        m.lastFeed,
+	}
+	if m.lastFeed != nil {
+		feedStatus.ItemCount = len(m.lastFeed.messageData())
+	}
+	return
+}
+
+// feedMonitorStatus is a copy of a monitor's state that is safe to read without holding its lock
+type feedMonitorStatus struct {
+	URL         string
+	Type        string
+	Paused      bool
+	Running     bool
+	LastPoll    time.Time
+	LastSuccess time.Time
+	LastNewItem time.Time
+	// consecutive failed polls, reset by a successful one
+	Failures  int
+	LastErr   error
+	ItemCount int
+	Feed      RSSFeed
+}
+
+var minBurstLimit = 1.0
+
+func feedTypeChoices() []*discordgo.ApplicationCommandOptionChoice {
+	names := make([]string, 0, len(feedTypes))
+	for name := range feedTypes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	choices := make([]*discordgo.ApplicationCommandOptionChoice, 0, len(names))
+	for _, name := range names {
+		choices = append(choices, &discordgo.ApplicationCommandOptionChoice{Name: name, Value: name})
+	}
+	return choices
+}
+
+func feedCommand() *discordgo.ApplicationCommand {
+	urlOption := func(required bool) *discordgo.ApplicationCommandOption {
+		return &discordgo.ApplicationCommandOption{
+			Type:        discordgo.ApplicationCommandOptionString,
+			Name:        "url",
+			Description: "The URL of the RSS feed",
+			Required:    required,
+		}
+	}
+	typeOption := func(required bool) *discordgo.ApplicationCommandOption {
+		return &discordgo.ApplicationCommandOption{
+			Type:        discordgo.ApplicationCommandOptionString,
+			Name:        "type",
+			Description: "Which parser to use for the feed",
+			Required:    required,
+			Choices:     feedTypeChoices(),
+		}
+	}
+
+	return &discordgo.ApplicationCommand{
+		Name:        "feed",
+		Description: "Manage the monitored RSS feeds",
+
+		Options: []*discordgo.ApplicationCommandOption{
+			{
+				Type:        discordgo.ApplicationCommandOptionSubCommand,
+				Name:        "add",
+				Description: "Start monitoring a new feed",
+				Options:     append([]*discordgo.ApplicationCommandOption{urlOption(true), typeOption(true)}, backfillOptions()...),
+			},
+			{
+				Type:        discordgo.ApplicationCommandOptionSubCommand,
+				Name:        "remove",
+				Description: "Stop monitoring a feed",
+				Options:     []*discordgo.ApplicationCommandOption{urlOption(true)},
+			},
+			{
+				Type:        discordgo.ApplicationCommandOptionSubCommand,
+				Name:        "list",
+				Description: "List the monitored feeds",
+			},
+			{
+				Type:        discordgo.ApplicationCommandOptionSubCommand,
+				Name:        "pause",
+				Description: "Stop posting from a feed until it is resumed",
+				Options:     []*discordgo.ApplicationCommandOption{urlOption(true)},
+			},
+			{
+				Type:        discordgo.ApplicationCommandOptionSubCommand,
+				Name:        "resume",
+				Description: "Resume a paused or stopped feed",
+				Options:     []*discordgo.ApplicationCommandOption{urlOption(true)},
+			},
+			{
+				Type:        discordgo.ApplicationCommandOptionSubCommand,
+				Name:        "poll",
+				Description: "Poll a feed now instead of waiting for its next scheduled poll",
+				Options:     []*discordgo.ApplicationCommandOption{urlOption(true)},
+			},
+			{
+				Type:        discordgo.ApplicationCommandOptionSubCommand,
+				Name:        "burst",
+				Description: "Set how many new items a feed can post at once before asking for confirmation",
+				Options: []*discordgo.ApplicationCommandOption{
+					urlOption(true),
+					{
+						Type:        discordgo.ApplicationCommandOptionInteger,
+						Name:        "limit",
+						Description: fmt.Sprintf("The most new items to post without asking, %d by default", defaultBurstLimit),
+						Required:    true,
+						MinValue:    &minBurstLimit,
+					},
+				},
+			},
+			{
+				Type:        discordgo.ApplicationCommandOptionSubCommand,
+				Name:        "updates",
+				Description: "Set what happens when the outlet changes an article that's already been posted",
+				Options: []*discordgo.ApplicationCommandOption{
+					urlOption(true),
+					{
+						Type:        discordgo.ApplicationCommandOptionString,
+						Name:        "policy",
+						Description: "What to do with changed articles, edit the earlier message by default",
+						Required:    true,
+						Choices:     updatePolicyChoices(),
+					},
+				},
+			},
+			{
+				Type:        discordgo.ApplicationCommandOptionSubCommand,
+				Name:        "topics",
+				Description: "Only post a feed's new items about some topics",
+				Options: []*discordgo.ApplicationCommandOption{
+					urlOption(true),
+					{
+						Type:        discordgo.ApplicationCommandOptionString,
+						Name:        "topics",
+						Description: "Comma separated topic tags, leave out to post everything",
+						Required:    false,
+					},
+				},
+			},
+			{
+				Type:        discordgo.ApplicationCommandOptionSubCommand,
+				Name:        "route",
+				Description: "Post articles about a topic to another channel instead of the news channel",
+				Options: []*discordgo.ApplicationCommandOption{
+					{
+						Type:        discordgo.ApplicationCommandOptionString,
+						Name:        "topic",
+						Description: "The topic tag",
+						Required:    true,
+						Choices:     topicChoices(),
+					},
+					{
+						Type:         discordgo.ApplicationCommandOptionChannel,
+						Name:         "channel",
+						Description:  "Where to post them. Leave out to post them to the news channel again",
+						Required:     false,
+						ChannelTypes: []discordgo.ChannelType{discordgo.ChannelTypeGuildText, discordgo.ChannelTypeGuildNews},
+					},
+				},
+			},
+			{
+				Type:        discordgo.ApplicationCommandOptionSubCommand,
+				Name:        "test",
+				Description: "Fetch a feed and preview the next items without posting them",
+				Options:     []*discordgo.ApplicationCommandOption{urlOption(true), typeOption(false)},
+			},
+			{
+				Type:        discordgo.ApplicationCommandOptionSubCommand,
+				Name:        "status",
+				Description: "Show the last poll time, last error and item count of the feeds",
+				Options:     []*discordgo.ApplicationCommandOption{urlOption(false)},
+			},
+		},
+	}
+}
+
+func feedCommandHandler(s discordClient, i *discordgo.InteractionCreate) {
+	/*
+		Dispatch the /feed subcommands. Looking at the feeds needs view_status, changing them needs manage_feeds, and
+		changes are saved to the state file so they survive a restart.
+	*/
+	subcommand := i.ApplicationCommandData().Options[0]
+	needed := capManageFeeds
+	if subcommand.Name == "list" || subcommand.Name == "test" || subcommand.Name == "status" {
+		needed = capViewStatus
+	}
+	if !checkInteractionCapability(s, i, needed) {
+		return
+	}
+
+	optionMap := optionsMap(subcommand.Options)
+	url := ""
+	if opt, ok := optionMap["url"]; ok {
+		url = strings.TrimSpace(opt.StringValue())
+	}
+
+	switch subcommand.Name {
+	case "add":
+		feedAddHandler(s, i, url, optionMap)
+	case "remove":
+		if _, ok := store.lookupFeed(url); !ok {
+			interactionRespond(s, i, fmt.Sprintf("Not monitoring %s", url))
+			return
+		}
+		scheduler.remove(url)
+		if err := store.removeFeed(url); err != nil {
+			slog.Error("saving state", "err", err)
+		}
+		auditInteraction(i, "removed")
+		interactionRespond(s, i, fmt.Sprintf("Stopped monitoring %s", url))
+	case "list":
+		var lines []string
+		for _, feed := range store.listFeeds() {
+			line := fmt.Sprintf("`%s` %s", feed.Type, feed.URL)
+			if feed.Paused {
+				line += " (paused)"
+			}
+			if feed.BurstLimit > 0 {
+				line += fmt.Sprintf(" (burst limit %d)", feed.BurstLimit)
+			}
+			if feed.UpdatePolicy != "" {
+				line += fmt.Sprintf(" (updates: %s)", feed.UpdatePolicy)
+			}
+			if len(feed.Topics) > 0 {
+				line += fmt.Sprintf(" (topics: %s)", strings.Join(feed.Topics, ", "))
+			}
+			lines = append(lines, line)
+		}
+		if routes := store.topicRoutes(); len(routes) > 0 {
+			lines = append(lines, "Topics posted elsewhere:", describeRoutes(routes))
+		}
+		if len(lines) == 0 {
+			interactionRespond(s, i, "No feeds are being monitored")
+			return
+		}
+		interactionRespond(s, i, strings.Join(lines, "\n"))
+	case "pause", "resume":
+		feedPauseHandler(s, i, url, subcommand.Name == "pause")
+	case "burst":
+		feed, ok, err := store.updateFeed(url, func(feed *feedConfig) { feed.BurstLimit = int(optionMap["limit"].IntValue()) })
+		if err != nil {
+			slog.Error("saving state", "err", err)
+		}
+		if !ok {
+			interactionRespond(s, i, fmt.Sprintf("Not monitoring %s", url))
+			return
+		}
+		auditInteraction(i, "burst limit set")
+		interactionRespond(s, i, fmt.Sprintf("More than %d new items at once from %s will be held for confirmation", feed.BurstLimit, url))
+	case "updates":
+		feed, ok, err := store.updateFeed(url, func(feed *feedConfig) { feed.UpdatePolicy = optionMap["policy"].StringValue() })
+		if err != nil {
+			slog.Error("saving state", "err", err)
+		}
+		if !ok {
+			interactionRespond(s, i, fmt.Sprintf("Not monitoring %s", url))
+			return
+		}
+		auditInteraction(i, "update policy set")
+		interactionRespond(s, i, fmt.Sprintf("Changed articles from %s will be handled with: %s", url, feed.UpdatePolicy))
+	case "topics":
+		text := ""
+		if opt, ok := optionMap["topics"]; ok {
+			text = opt.StringValue()
+		}
+		topics, err := parseTopics(text)
+		if err != nil {
+			interactionRespond(s, i, err.Error())
+			return
+		}
+		feed, ok, err := store.updateFeed(url, func(feed *feedConfig) { feed.Topics = topics })
+		if err != nil {
+			slog.Error("saving state", "err", err)
+		}
+		if !ok {
+			interactionRespond(s, i, fmt.Sprintf("Not monitoring %s", url))
+			return
+		}
+		auditInteraction(i, "topics set")
+		if len(feed.Topics) == 0 {
+			interactionRespond(s, i, fmt.Sprintf("Posting every new item from %s", url))
+			return
+		}
+		interactionRespond(s, i, fmt.Sprintf("Only posting new items from %s about %s", url, strings.Join(feed.Topics, ", ")))
+	case "route":
+		topic := optionMap["topic"].StringValue()
+		channelID := ""
+		if opt, ok := optionMap["channel"]; ok {
+			channelID = opt.ChannelValue(nil).ID
+		}
+		if err := store.setTopicRoute(topic, channelID); err != nil {
+			slog.Error("saving state", "err", err)
+		}
+		auditInteraction(i, "topic routed")
+		if channelID == "" {
+			interactionRespond(s, i, fmt.Sprintf("Articles about %s will be posted to the news channel", topic))
+			return
+		}
+		interactionRespond(s, i, fmt.Sprintf("Articles about %s will be posted to <#%s>", topic, channelID))
+	case "poll":
+		if err := scheduler.triggerPoll(url); err != nil {
+			interactionRespond(s, i, fmt.Sprintf("Can't poll %s: %v", url, err))
+			return
+		}
+		auditInteraction(i, "polled")
+		interactionRespond(s, i, fmt.Sprintf("Polling %s now", url))
+	case "test":
+		feedType := ""
+		if opt, ok := optionMap["type"]; ok {
+			feedType = opt.StringValue()
+		}
+		feedTestHandler(s, i, url, feedType)
+	case "status":
+		feedStatusHandler(s, i, url)
+	}
+}
+
+func feedAddHandler(s discordClient, i *discordgo.InteractionCreate, url string, optionMap map[string]*discordgo.ApplicationCommandInteractionDataOption) {
+	if _, ok := store.lookupFeed(url); ok {
+		interactionRespond(s, i, fmt.Sprintf("Already monitoring %s", url))
+		return
+	}
+	feedType := optionMap["type"].StringValue()
+	feed := feedConfig{URL: url, Type: feedType, AddedBy: i.Member.User.Username, AddedAt: time.Now()}
+	if err := feed.setBackfill(optionMap); err != nil {
+		interactionRespond(s, i, err.Error())
+		return
+	}
+
+	// fetching the feed can take longer than Discord waits for a response
+	interactionDefer(s, i)
+	if _, err := fetchFeed(botContext, url, feedTypes[feedType]); err != nil {
+		interactionEdit(s, i, fmt.Sprintf("Could not read %s as a %s feed: %v", url, feedType, err))
+		return
+	}
+
+	if err := store.saveFeed(feed); err != nil {
+		slog.Error("saving state", "err", err)
+	}
+	if _, err := scheduler.add(feed); err != nil {
+		interactionEdit(s, i, fmt.Sprintf("Failed to start monitoring %s: %v", url, err))
+		return
+	}
+	auditInteraction(i, "added")
+	interactionEdit(s, i, fmt.Sprintf("Now monitoring %s, %s", url, feed.describeBackfill()))
+}
+
+func feedPauseHandler(s discordClient, i *discordgo.InteractionCreate, url string, paused bool) {
+	feed, ok, err := store.updateFeed(url, func(feed *feedConfig) { feed.Paused = paused })
+	if err != nil {
+		slog.Error("saving state", "err", err)
+	}
+	if !ok {
+		interactionRespond(s, i, fmt.Sprintf("Not monitoring %s", url))
+		return
+	}
+	if paused {
+		auditInteraction(i, "paused")
+	} else {
+		auditInteraction(i, "resumed")
+	}
+
+	monitor, ok := scheduler.lookup(url)
+	if ok {
+		monitor.setPaused(paused)
+	}
+	if paused {
+		interactionRespond(s, i, fmt.Sprintf("Paused %s", url))
+		return
+	}
+
+	// resuming a feed whose loop stopped after an error starts it again
+	if !ok || !monitor.snapshot().Running {
+		if _, err := scheduler.add(feed); err != nil {
+			interactionRespond(s, i, fmt.Sprintf("Failed to restart %s: %v", url, err))
+			return
+		}
+	}
+	interactionRespond(s, i, fmt.Sprintf("Resumed %s", url))
+}
+
+func feedTestHandler(s discordClient, i *discordgo.InteractionCreate, url string, feedType string) {
+	/*
+		Show what the next poll would post. For a monitored feed that's the difference against the last snapshot,
+		for any other URL it's just the newest items in the feed.
+	*/
+	var lastFeed RSSFeed
+	if monitor, ok := scheduler.lookup(url); ok {
+		feedStatus := monitor.snapshot()
+		lastFeed = feedStatus.Feed
+		if feedType == "" {
+			feedType = feedStatus.Type
+		}
+	}
+	newFeed, ok := feedTypes[feedType]
+	if !ok {
+		interactionRespond(s, i, fmt.Sprintf("%s is not monitored, so a type is needed to test it", url))
+		return
+	}
+
+	interactionDefer(s, i)
+	currentFeed, err := fetchFeed(botContext, url, newFeed)
+	if err != nil {
+		interactionEdit(s, i, fmt.Sprintf("Could not read %s as a %s feed: %v", url, feedType, err))
+		return
+	}
+
+	var (
+		items  []discordMessageData
+		header string
+	)
+	if lastFeed != nil {
+		if items, err = parseNewRssContent(botContext, lastFeed, currentFeed); err != nil {
+			interactionEdit(s, i, fmt.Sprintf("Parsing %s failed: %v", url, err))
+			return
+		}
+		header = fmt.Sprintf("%d item(s) would be posted from %s on the next poll", len(items), url)
+	} else {
+		items = currentFeed.messageData()
+		header = fmt.Sprintf("%s has %d item(s), the newest are", url, len(items))
+	}
+
+	embeds := make([]*discordgo.MessageEmbed, 0, 5)
+	for _, item := range items {
+		if len(embeds) == cap(embeds) {
+			break
+		}
+		embeds = append(embeds, newsEmbed(item))
+	}
+	interactionEditEmbeds(s, i, header, embeds)
+}
+
+func feedStatusHandler(s discordClient, i *discordgo.InteractionCreate, url string) {
+	/*
+		An embed holds at most 25 fields and 6000 characters, so once it's full the rest of the feeds are only
+		counted, and can be looked at one at a time with a url
+	*/
+	var (
+		fields  []*discordgo.MessageEmbedField
+		matched int
+		size    = len("Feed status")
+	)
+	for _, feedStatus := range scheduler.statuses() {
+		if url != "" && feedStatus.URL != url {
+			continue
+		}
+		matched++
+		field := &discordgo.MessageEmbedField{
+			Name:  truncate(feedStatus.URL, 256),
+			Value: feedStatus.describe(),
+		}
+		fieldSize := len([]rune(field.Name)) + len([]rune(field.Value))
+		if len(fields) == 25 || size+fieldSize > 5800 {
+			continue
+		}
+		size += fieldSize
+		fields = append(fields, field)
+	}
+	if len(fields) == 0 {
+		interactionRespond(s, i, "No matching feeds are being monitored")
+		return
+	}
+
+	embed := &discordgo.MessageEmbed{
+		Type:   discordgo.EmbedTypeRich,
+		Title:  "Feed status",
+		Fields: fields,
+	}
+	if hidden := matched - len(fields); hidden > 0 {
+		embed.Description = fmt.Sprintf("…and %d more, use `/feed status <url>` to see one", hidden)
+	}
+	interactionRespondEmbeds(s, i, []*discordgo.MessageEmbed{embed})
+}
+
+func (feedStatus feedMonitorStatus) describe() string {
+	state := "running"
+	if feedStatus.Paused {
+		state = "paused"
+	} else if !feedStatus.Running {
+		state = "stopped"
+	}
+
+	lastPoll, lastSuccess := "never", "never"
+	if !feedStatus.LastPoll.IsZero() {
+		lastPoll = fmt.Sprintf("<t:%d:R>", feedStatus.LastPoll.Unix())
+	}
+	if !feedStatus.LastSuccess.IsZero() {
+		lastSuccess = fmt.Sprintf("<t:%d:R>", feedStatus.LastSuccess.Unix())
+	}
+
+	lastErr := "none"
+	if feedStatus.LastErr != nil {
+		// a field value can't be over 1024 characters
+		lastErr = truncate(feedStatus.LastErr.Error(), 500)
+	}
+
+	return fmt.Sprintf("type: %s, %s\nlast poll: %s\nlast success: %s\nconsecutive failures: %d\nitems: %d\nlast error: %s",
+		feedStatus.Type, state, lastPoll, lastSuccess, feedStatus.Failures, feedStatus.ItemCount, lastErr)
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+func TestFeedStatusFitsEmbedLimits(t *testing.T) {
+	fake := setupFakeDiscord(t)
+	defer func(old *feedScheduler) { scheduler = old }(scheduler)
+	scheduler = &feedScheduler{jobs: map[string]*feedMonitor{}}
+	for idx := 0; idx < 40; idx++ {
+		url := fmt.Sprintf("https://example.com/feed-%02d", idx)
+		scheduler.jobs[url] = &feedMonitor{url: url, feedType: "zdi", lastErr: errors.New(strings.Repeat("connection reset ", 200))}
+	}
+	admin := testMember("admin", discordgo.PermissionAdministrator)
+
+	interactionHandler(fake, commandInteraction("feed", admin, subcommandOption("status")))
+	embeds := fake.responses[len(fake.responses)-1].Data.Embeds
+	if len(embeds) != 1 {
+		t.Fatalf("expected the status embed, got %+v", fake.responses[len(fake.responses)-1].Data)
+	}
+	embed := embeds[0]
+	size := len(embed.Title) + len([]rune(embed.Description))
+	for _, field := range embed.Fields {
+		if value := len([]rune(field.Value)); value > 1024 {
+			t.Errorf("%s: field value is %d characters", field.Name, value)
+		}
+		size += len([]rune(field.Name)) + len([]rune(field.Value))
+	}
+	if len(embed.Fields) > 25 || size > 6000 {
+		t.Errorf("embed has %d fields and %d characters", len(embed.Fields), size)
+	}
+	if hidden := 40 - len(embed.Fields); !strings.HasPrefix(embed.Description, fmt.Sprintf("…and %d more", hidden)) {
+		t.Errorf("expected the hidden feeds to be counted, got %q", embed.Description)
+	}
+
+	// and one feed can still be looked at on its own
+	interactionHandler(fake, commandInteraction("feed", admin, subcommandOption("status", stringOption("url", "https://example.com/feed-39"))))
+	if fields := fake.responses[len(fake.responses)-1].Data.Embeds[0].Fields; len(fields) != 1 || fields[0].Name != "https://example.com/feed-39" {
+		t.Errorf("expected just the one feed, got %+v", fields)
+	}
+}
@@ -4,10 +4,12 @@ Code for handling the RSS feed provided by The Hacker News
 package main
 
 import (
+	"context"
 	"encoding/xml"
 	"errors"
+	"fmt"
 	"io"
-	"log"
+	"log/slog"
 	"net/http"
 	"regexp"
 	"strings"
@@ -50,6 +52,9 @@ type hackerNewsHtml struct {
 	} `xml:"body"`
 }
 
+// lazy, so the match ends at the tags' own </span> rather than the last one on the line
+var categoryPattern = regexp.MustCompile(`<span class='p-tags'(.*?)</span>`)
+
 var interestingList = []string{
 	"Vulnerability",
 	"Zero-Day",
@@ -67,53 +72,91 @@ var interestingList = []string{
 	"Linux",
 }
 
-func (hn *HackerNewsRssFeed) getPageCategories(pageUrl string) (string, error) {
+func (hn *HackerNewsRssFeed) getPageCategories(ctx context.Context, pageUrl string) (string, error) {
 	/*
 		Scrapes the page for the categories of the article
 	*/
 	var (
+		req  *http.Request
 		resp *http.Response
 		body []byte
 		err  error
 	)
 
-	if resp, err = http.Get(pageUrl); err != nil {
-		log.Fatalln("err: ", err)
+	if req, err = http.NewRequestWithContext(ctx, http.MethodGet, pageUrl, nil); err != nil {
+		return "", err
+	}
+	if resp, err = http.DefaultClient.Do(req); err != nil {
+		return "", err
 	}
 
 	defer resp.Body.Close()
-	if body, err = io.ReadAll(resp.Body); err != nil {
-		log.Fatalln("err: ", err)
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("err: article status code was '%d' not 200", resp.StatusCode)
+	}
+	// the tags are near the top of the article, and a page bigger than this isn't one of theirs
+	if body, err = io.ReadAll(io.LimitReader(resp.Body, pageSizeCap)); err != nil {
+		return "", err
 	}
 
-	re := regexp.MustCompile(`<span class='p-tags'(.*)</span>`)
-	match := re.FindStringSubmatch(string(body))
-	log.Printf("%v", match)
+	category := parsePageCategories(string(body))
+	if category != "" {
+		slog.Debug("scraped article categories", "link", pageUrl, "categories", category)
+	}
+	return category, nil
+}
 
+func parsePageCategories(body string) string {
+	match := categoryPattern.FindStringSubmatch(body)
 	if len(match) > 1 {
-		log.Printf("Not posting article '%v' due to lack of interesting tags\n", pageUrl)
-		return match[1], nil
+		return match[1]
 	}
-
-	return "", nil
-
+	return ""
 }
 
 func (hn *HackerNewsRssFeed) filterNewsCats(category string) bool {
 	/*
 		Filter out articles that are not interesting, using the tags provided by the website
 	*/
+	return len(hn.interestingCats(category)) > 0
+}
 
-	//log.Println(category)
+func (hn *HackerNewsRssFeed) interestingCats(category string) []string {
+	/*
+		The interesting tags found in the scraped categories, which are kept as the item's categories
+	*/
+	var cats []string
 	for _, str := range interestingList {
 		if strings.Contains(category, str) {
-			return true
+			cats = append(cats, str)
 		}
 	}
-	return false
+	return cats
+}
+
+func (item hackerNewsRssItem) messageData() discordMessageData {
+	id := item.GUID
+	if id == "" {
+		id = item.Link
+	}
+	return discordMessageData{
+		ID:          id,
+		Title:       item.Title,
+		Description: item.Description,
+		Link:        item.Link,
+		Published:   parseFeedDate(item.PubDate),
+	}
+}
+
+func (hn *HackerNewsRssFeed) messageData() []discordMessageData {
+	items := make([]discordMessageData, 0, len(hn.Channel.Items))
+	for _, item := range hn.Channel.Items {
+		items = append(items, item.messageData())
+	}
+	return items
 }
 
-func (hn *HackerNewsRssFeed) ParseNewRssContent(oldData RSSFeed, newData RSSFeed) ([]discordMessageData, error) {
+func (hn *HackerNewsRssFeed) ParseNewRssContent(ctx context.Context, oldData RSSFeed, newData RSSFeed) ([]discordMessageData, error) {
 	var (
 		oldHNData  *HackerNewsRssFeed
 		newHNData  *HackerNewsRssFeed
@@ -130,39 +173,29 @@ func (hn *HackerNewsRssFeed) ParseNewRssContent(oldData RSSFeed, newData RSSFeed
 		return nil, errors.New("error: newData is not of type HackerNewsRssFeed")
 	}
 
-	for _, newFeedItem := range newHNData.Channel.Items {
-		itemExists := false
-		for _, oldFeedItem := range oldHNData.Channel.Items {
-			if newFeedItem.Title == oldFeedItem.Title {
-				log.Printf("Article titled '%v' already exists in old data. Stopping iteration.\n", newFeedItem.Title)
-				itemExists = true
-				break
-			}
+	addedContent, updatedContent := diffRssItems(oldHNData.messageData(), newHNData.messageData())
+	for _, newFeedItem := range addedContent {
+		itemLog := slog.With("item", newFeedItem.itemKey(), "title", newFeedItem.Title)
+		itemLog.Debug("new article")
+
+		category, err := hn.getPageCategories(ctx, newFeedItem.Link)
+		if err != nil {
+			// without the categories there's no telling whether the article is interesting, so leave it out
+			itemLog.Warn("scraping article categories", "err", err)
+			continue
 		}
-		if !itemExists {
-			log.Printf("Article '%v' is new", newFeedItem.Title)
-
-			category, err := hn.getPageCategories(newFeedItem.Link)
-			if err != nil {
-				log.Fatalf("err: %v\n", err)
-			}
-
-			interestingCategory := hn.filterNewsCats(category)
-			if !interestingCategory {
-				log.Printf("'%v' is not an interesting item, skipping", interestingCategory)
-				continue
-			}
-
-			messageContent := discordMessageData{
-				Title:       newFeedItem.Title,
-				Description: newFeedItem.Description,
-				Link:        newFeedItem.Link,
-			}
-			newContent = append(newContent, messageContent)
+
+		interestingCategory := hn.filterNewsCats(category)
+		if !interestingCategory {
+			itemLog.Info("not an interesting item, skipping")
+			continue
 		}
+
+		newFeedItem.Categories = hn.interestingCats(category)
+		newContent = append(newContent, newFeedItem)
 	}
-	if len(newContent) > 20 {
-		return nil, errors.New("err: more than 20 new items. Logic bug likely. Exiting")
-	}
+
+	// edits to articles only matter if we posted the article in the first place, which submitNewRssContent checks
+	newContent = append(newContent, updatedContent...)
 	return newContent, nil
 }
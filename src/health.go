@@ -0,0 +1,180 @@
+/*
+Health reporting for running the bot in a container. /healthz only fails when the Discord gateway has been down for longer
+than discordgo takes to reconnect, so the orchestrator restarts the bot when a restart would help, and not because of one
+broken feed. /readyz also fails while the gateway is disconnected or any feed's poll loop has stopped. Both return the same
+JSON report, which the /status command shows to admins as an embed.
+*/
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+// a feed is reported unhealthy after this many failed polls in a row
+const feedFailureThreshold = 3
+
+// how long the gateway can be disconnected before the bot counts as dead, discordgo reconnects well within this
+const gatewayGracePeriod = 5 * time.Minute
+
+var (
+	gatewayMu        sync.Mutex
+	gatewayConnected bool
+	gatewaySince     time.Time
+)
+
+type gatewayHealth struct {
+	Connected bool      `json:"connected"`
+	Since     time.Time `json:"since,omitempty"`
+}
+
+type feedHealth struct {
+	URL                 string    `json:"url"`
+	Type                string    `json:"type"`
+	Running             bool      `json:"running"`
+	Paused              bool      `json:"paused"`
+	LastSuccess         time.Time `json:"last_success,omitempty"`
+	ConsecutiveFailures int       `json:"consecutive_failures"`
+	LastError           string    `json:"last_error,omitempty"`
+	Healthy             bool      `json:"healthy"`
+}
+
+type healthReport struct {
+	Live    bool          `json:"live"`
+	Ready   bool          `json:"ready"`
+	Gateway gatewayHealth `json:"gateway"`
+	// the feeds whose poll loop broke out after an error, /feed resume starts them again
+	StoppedFeeds int          `json:"stopped_feeds"`
+	Feeds        []feedHealth `json:"feeds"`
+}
+
+func setGatewayConnected(connected bool) {
+	gatewayMu.Lock()
+	defer gatewayMu.Unlock()
+	if connected != gatewayConnected || gatewaySince.IsZero() {
+		gatewayConnected = connected
+		gatewaySince = time.Now()
+	}
+}
+
+func trackGatewayState(session *discordgo.Session) {
+	/*
+		discordgo reconnects by itself, these just keep track of whether it currently is connected
+	*/
+	session.AddHandler(func(s *discordgo.Session, event *discordgo.Connect) { setGatewayConnected(true) })
+	session.AddHandler(func(s *discordgo.Session, event *discordgo.Resumed) { setGatewayConnected(true) })
+	session.AddHandler(func(s *discordgo.Session, event *discordgo.Disconnect) { setGatewayConnected(false) })
+}
+
+func currentHealth() (report healthReport) {
+	gatewayMu.Lock()
+	report.Gateway = gatewayHealth{Connected: gatewayConnected, Since: gatewaySince}
+	gatewayMu.Unlock()
+
+	// nothing has connected yet while the bot is starting up
+	report.Live = report.Gateway.Connected || report.Gateway.Since.IsZero() || time.Since(report.Gateway.Since) < gatewayGracePeriod
+	report.Feeds = []feedHealth{}
+	for _, feedStatus := range scheduler.statuses() {
+		feed := feedHealth{
+			URL:                 feedStatus.URL,
+			Type:                feedStatus.Type,
+			Running:             feedStatus.Running,
+			Paused:              feedStatus.Paused,
+			LastSuccess:         feedStatus.LastSuccess,
+			ConsecutiveFailures: feedStatus.Failures,
+		}
+		if feedStatus.LastErr != nil {
+			feed.LastError = feedStatus.LastErr.Error()
+		}
+		feed.Healthy = feed.Running && feed.ConsecutiveFailures < feedFailureThreshold
+		if !feed.Running {
+			report.StoppedFeeds++
+		}
+		report.Feeds = append(report.Feeds, feed)
+	}
+	report.Ready = report.Gateway.Connected && report.StoppedFeeds == 0
+	return
+}
+
+func writeHealth(w http.ResponseWriter, ok bool, report healthReport) {
+	w.Header().Set("Content-Type", "application/json")
+	if !ok {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	encoder.Encode(report)
+}
+
+func healthzHandler(w http.ResponseWriter, r *http.Request) {
+	report := currentHealth()
+	writeHealth(w, report.Live, report)
+}
+
+func readyzHandler(w http.ResponseWriter, r *http.Request) {
+	report := currentHealth()
+	writeHealth(w, report.Ready, report)
+}
+
+var statusCommand = &discordgo.ApplicationCommand{
+	Name:        "status",
+	Description: "Show whether the bot is connected and its feeds are being polled",
+}
+
+func statusCommandHandler(s discordClient, i *discordgo.InteractionCreate) {
+	if !checkInteractionCapability(s, i, capViewStatus) {
+		return
+	}
+	interactionRespondEmbeds(s, i, []*discordgo.MessageEmbed{currentHealth().embed()})
+}
+
+func (report healthReport) embed() *discordgo.MessageEmbed {
+	gateway := "disconnected"
+	if report.Gateway.Connected {
+		gateway = "connected"
+	}
+	if !report.Gateway.Since.IsZero() {
+		gateway += fmt.Sprintf(" since <t:%d:R>", report.Gateway.Since.Unix())
+	}
+
+	embed := &discordgo.MessageEmbed{
+		Type:        discordgo.EmbedTypeRich,
+		Title:       "Bot status",
+		Description: fmt.Sprintf("Gateway: %s\nLive: %t, ready: %t, stopped feeds: %d", gateway, report.Live, report.Ready, report.StoppedFeeds),
+		Color:       0x2ecc71,
+	}
+	if !report.Ready {
+		embed.Color = 0xe74c3c
+	}
+
+	for _, feed := range report.Feeds {
+		if len(embed.Fields) == 25 {
+			// the most fields an embed can have
+			break
+		}
+		state := "healthy"
+		if feed.Paused {
+			state = "paused"
+		} else if !feed.Running {
+			state = "stopped"
+		} else if !feed.Healthy {
+			state = "failing"
+		}
+		lastSuccess := "never"
+		if !feed.LastSuccess.IsZero() {
+			lastSuccess = fmt.Sprintf("<t:%d:R>", feed.LastSuccess.Unix())
+		}
+		value := fmt.Sprintf("%s (%s)\nlast success: %s\nconsecutive failures: %d",
+			state, feed.Type, lastSuccess, feed.ConsecutiveFailures)
+		if feed.LastError != "" {
+			value += "\nlast error: " + truncate(feed.LastError, 200)
+		}
+		embed.Fields = append(embed.Fields, &discordgo.MessageEmbedField{Name: truncate(feed.URL, 256), Value: value})
+	}
+	return embed
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// setHealthState points the scheduler at one stopped and one running feed, and sets the gateway's state
+func setHealthState(t *testing.T, connected bool, since time.Time) {
+	t.Helper()
+	oldScheduler := scheduler
+	gatewayMu.Lock()
+	oldConnected, oldSince := gatewayConnected, gatewaySince
+	gatewayConnected, gatewaySince = connected, since
+	gatewayMu.Unlock()
+	t.Cleanup(func() {
+		scheduler = oldScheduler
+		gatewayMu.Lock()
+		gatewayConnected, gatewaySince = oldConnected, oldSince
+		gatewayMu.Unlock()
+	})
+
+	scheduler = &feedScheduler{jobs: map[string]*feedMonitor{
+		"https://example.com/stopped": {url: "https://example.com/stopped", feedType: "zdi"},
+		"https://example.com/running": {url: "https://example.com/running", feedType: "zdi", running: true},
+	}}
+}
+
+func healthStatus(handler http.HandlerFunc) int {
+	recorder := httptest.NewRecorder()
+	handler(recorder, httptest.NewRequest(http.MethodGet, "/", nil))
+	return recorder.Code
+}
+
+func TestStoppedFeedOnlyFailsReadiness(t *testing.T) {
+	setHealthState(t, true, time.Now())
+
+	if code := healthStatus(healthzHandler); code != http.StatusOK {
+		t.Errorf("a stopped feed failed liveness with %d", code)
+	}
+	if code := healthStatus(readyzHandler); code != http.StatusServiceUnavailable {
+		t.Errorf("a stopped feed should fail readiness, got %d", code)
+	}
+	if report := currentHealth(); report.StoppedFeeds != 1 || len(report.Feeds) != 2 {
+		t.Errorf("expected the stopped feed in the report, got %+v", report)
+	}
+}
+
+func TestGatewayDownFailsLivenessAfterGrace(t *testing.T) {
+	setHealthState(t, false, time.Now())
+	if code := healthStatus(healthzHandler); code != http.StatusOK {
+		t.Errorf("a gateway that just dropped failed liveness with %d", code)
+	}
+
+	setHealthState(t, false, time.Now().Add(-gatewayGracePeriod-time.Minute))
+	if code := healthStatus(healthzHandler); code != http.StatusServiceUnavailable {
+		t.Errorf("a gateway down past the grace period should fail liveness, got %d", code)
+	}
+}
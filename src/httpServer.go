@@ -0,0 +1,38 @@
+/*
+The bot's HTTP listener, which serves the operational endpoints: /metrics, /healthz and /readyz. It's bound to
+HTTP_ADDR, ":8080" unless configured otherwise.
+*/
+package main
+
+import (
+	"log/slog"
+	"net/http"
+	"time"
+)
+
+const defaultHTTPAddr = ":8080"
+
+var httpMux = http.NewServeMux()
+
+func startHTTPServer(addr string) *http.Server {
+	/*
+		Serve the operational endpoints in the background. A server that can't listen is logged but doesn't stop the
+		bot, which is still useful without them.
+	*/
+	httpMux.HandleFunc("/metrics", metricsHandler)
+	httpMux.HandleFunc("/healthz", healthzHandler)
+	httpMux.HandleFunc("/readyz", readyzHandler)
+
+	server := &http.Server{
+		Addr:              addr,
+		Handler:           httpMux,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	go func() {
+		slog.Info("serving HTTP endpoints", "addr", addr)
+		if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			slog.Error("HTTP server stopped", "addr", addr, "err", err)
+		}
+	}()
+	return server
+}
@@ -0,0 +1,220 @@
+/*
+Working out the article link of a feed item. Atom entries carry several links, only one of which is the article page,
+and some outlets send readers through a redirector first, so both are sorted out here before an item is posted. Items
+whose link can't be found are reported to the admin channel instead of being posted without one.
+*/
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+// atomLink is a <link> of an Atom feed or entry. Base is the xml:base in scope on the element itself, if any
+type atomLink struct {
+	Rel  string `xml:"rel,attr"`
+	Type string `xml:"type,attr"`
+	Href string `xml:"href,attr"`
+	Base string `xml:"http://www.w3.org/XML/1998/namespace base,attr"`
+}
+
+const maxRedirectHops = 5
+
+var (
+	// redirectors that carry the real address in a query parameter, keyed by host and path, or by host alone for
+	// the ones whose path changes from link to link
+	redirectorParams = map[string]string{
+		"www.google.com/url":   "q",
+		"l.facebook.com/l.php": "u",
+		"out.reddit.com":       "url",
+		"t.umblr.com/redirect": "z",
+	}
+	// redirectors that answer with an HTTP redirect to the real address, like FeedBurner's click tracking
+	redirectorHosts = map[string]bool{
+		"feedproxy.google.com": true,
+		"feeds.feedburner.com": true,
+		"t.co":                 true,
+		"bit.ly":               true,
+		"ow.ly":                true,
+		"lnkd.in":              true,
+	}
+
+	// redirects are followed by hand, one hop at a time, so only known redirectors are ever followed
+	redirectClient = &http.Client{
+		Timeout:       pageFetchTimeout,
+		CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse },
+	}
+)
+
+func resolveReference(base string, href string) string {
+	/*
+		Resolve href against base, leaving it as it is when there's no base or either doesn't parse
+	*/
+	href = strings.TrimSpace(href)
+	if base == "" {
+		return href
+	}
+	baseUrl, err := url.Parse(base)
+	if err != nil {
+		return href
+	}
+	ref, err := url.Parse(href)
+	if err != nil {
+		return href
+	}
+	return baseUrl.ResolveReference(ref).String()
+}
+
+func atomBase(base string, links []atomLink) string {
+	/*
+		The base URL for a feed's relative links: its xml:base, itself resolved against the feed's own web page or
+		self link, which is what most readers fall back on
+	*/
+	var page, self string
+	for _, link := range links {
+		switch link.Rel {
+		case "", "alternate":
+			if page == "" {
+				page = resolveReference(link.Base, link.Href)
+			}
+		case "self":
+			self = resolveReference(link.Base, link.Href)
+		}
+	}
+	if page == "" {
+		page = self
+	}
+	return resolveReference(page, base)
+}
+
+func resolveAtomLink(base string, links []atomLink) string {
+	/*
+		Pick the article page from an entry's links. A rel="alternate" link, which is also what a link without a rel
+		means, of type text/html wins, followed by an alternate link without a type. Links to anything else, like the
+		comments feed or an enclosure, are passed over. Returns "" when no link is an absolute web address once
+		resolved against base and the link's own xml:base.
+	*/
+	var untyped string
+	for _, link := range links {
+		if link.Rel != "" && link.Rel != "alternate" {
+			continue
+		}
+		mediaType := strings.ToLower(strings.TrimSpace(strings.Split(link.Type, ";")[0]))
+		if mediaType != "" && mediaType != "text/html" && mediaType != "application/xhtml+xml" {
+			continue
+		}
+
+		href := resolveReference(resolveReference(base, link.Base), link.Href)
+		if parsed, err := url.Parse(href); err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+			continue
+		}
+		if mediaType != "" {
+			return href
+		}
+		if untyped == "" {
+			untyped = href
+		}
+	}
+	return untyped
+}
+
+func unwrapRedirector(ctx context.Context, link string) string {
+	/*
+		Follow a link through any known redirectors to the page it ends up at. Anything going wrong on the way leaves
+		the link where it got to, which still takes readers to the article, just less directly.
+	*/
+	for hop := 0; hop < maxRedirectHops; hop++ {
+		parsed, err := url.Parse(link)
+		if err != nil {
+			return link
+		}
+
+		next := ""
+		param, ok := redirectorParams[parsed.Host+parsed.Path]
+		if !ok {
+			param, ok = redirectorParams[parsed.Host]
+		}
+		if ok {
+			next = parsed.Query().Get(param)
+		} else if redirectorHosts[parsed.Host] {
+			next = fetchRedirect(ctx, link)
+		}
+		if next == "" {
+			return link
+		}
+		if nextUrl, err := url.Parse(next); err != nil || (nextUrl.Scheme != "http" && nextUrl.Scheme != "https") {
+			return link
+		}
+		link = next
+	}
+	return link
+}
+
+func fetchRedirect(ctx context.Context, link string) string {
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet, link, nil)
+	if err != nil {
+		return ""
+	}
+	response, err := redirectClient.Do(request)
+	if err != nil {
+		slog.Warn("following redirector", "link", link, "err", err)
+		return ""
+	}
+	response.Body.Close()
+
+	location := response.Header.Get("Location")
+	if response.StatusCode < 300 || response.StatusCode >= 400 || location == "" {
+		return ""
+	}
+	return resolveReference(link, location)
+}
+
+func resolveItemLinks(ctx context.Context, feedUrl string, items []discordMessageData) []discordMessageData {
+	/*
+		Unwrap redirectors in the items' links, and hold back items that have no link at all, telling the admins
+		about the new ones so someone can look at the feed
+	*/
+	var (
+		resolved   []discordMessageData
+		unresolved []string
+	)
+	for _, item := range items {
+		if item.Link == "" {
+			if !item.Updated {
+				unresolved = append(unresolved, item.Title)
+			}
+			continue
+		}
+		item.Link = unwrapRedirector(ctx, item.Link)
+		resolved = append(resolved, item)
+	}
+	if len(unresolved) > 0 {
+		reportUnresolvedLinks(feedUrl, unresolved)
+	}
+	return resolved
+}
+
+func reportUnresolvedLinks(feedUrl string, titles []string) {
+	slog.Warn("items without a link weren't posted", "feed", feedUrl, "items", len(titles))
+	if discord == nil {
+		return
+	}
+
+	lines := []string{fmt.Sprintf(":warning: Couldn't find the article link of %d new item(s) from <%s>, so they weren't posted:", len(titles), feedUrl)}
+	for idx, title := range titles {
+		if idx == 10 {
+			lines = append(lines, fmt.Sprintf("…and %d more", len(titles)-idx))
+			break
+		}
+		lines = append(lines, "- "+truncate(title, 150))
+	}
+	if _, err := discord.sendMessage(adminChannelId, &discordgo.MessageSend{Content: strings.Join(lines, "\n")}); err != nil {
+		slog.Error("reporting items without a link", "feed", feedUrl, "err", err)
+	}
+}
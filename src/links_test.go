@@ -0,0 +1,139 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+const linksFeed = `<feed xmlns="http://www.w3.org/2005/Atom" xml:base="/blog/">
+  <link rel="self" type="application/atom+xml" href="https://example.com/feeds/posts/default"/>
+  <link rel="alternate" type="text/html" href="https://example.com/"/>
+  <entry>
+    <id>typed</id>
+    <title>Typed alternate wins</title>
+    <link rel="replies" type="text/html" href="https://example.com/comments/1"/>
+    <link rel="alternate" href="https://example.com/untyped"/>
+    <link rel="alternate" type="text/html" href="https://example.com/2024/01/typed.html"/>
+    <link rel="edit" type="application/atom+xml" href="https://example.com/edit/1"/>
+  </entry>
+  <entry>
+    <id>relative</id>
+    <title>Relative to the feed</title>
+    <link href="2024/02/relative.html"/>
+  </entry>
+  <entry xml:base="https://mirror.example.org/posts/">
+    <id>entry-base</id>
+    <title>Relative to the entry</title>
+    <link rel="alternate" type="text/html; charset=utf-8" href="entry.html"/>
+  </entry>
+  <entry>
+    <id>none</id>
+    <title>Only a comments feed</title>
+    <link rel="replies" type="application/atom+xml" href="https://example.com/comments/feed"/>
+    <link rel="alternate" type="text/html" href="javascript:alert(1)"/>
+  </entry>
+</feed>`
+
+func TestResolveAtomLinks(t *testing.T) {
+	feed, err := unmarshalFeed([]byte(linksFeed), feedTypes["projectzero"])
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]string{
+		"typed":      "https://example.com/2024/01/typed.html",
+		"relative":   "https://example.com/blog/2024/02/relative.html",
+		"entry-base": "https://mirror.example.org/posts/entry.html",
+		"none":       "",
+	}
+	for _, item := range feed.messageData() {
+		if item.Link != want[item.ID] {
+			t.Errorf("%s resolved to %q, want %q", item.ID, item.Link, want[item.ID])
+		}
+	}
+}
+
+func TestUnwrapRedirector(t *testing.T) {
+	// stands in for a redirector that answers with an HTTP redirect, two hops from the article
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/first":
+			http.Redirect(w, r, "/second", http.StatusMovedPermanently)
+		case "/second":
+			http.Redirect(w, r, "https://example.com/article", http.StatusFound)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+	serverUrl, _ := url.Parse(server.URL)
+	redirectorHosts[serverUrl.Host] = true
+	defer delete(redirectorHosts, serverUrl.Host)
+
+	links := map[string]string{
+		server.URL + "/first": "https://example.com/article",
+		server.URL + "/gone":  server.URL + "/gone",
+		"https://www.google.com/url?q=https%3A%2F%2Fexample.com%2Fquery&sa=D":                          "https://example.com/query",
+		"https://www.google.com/url?q=javascript%3Aalert(1)":                                           "https://www.google.com/url?q=javascript%3Aalert(1)",
+		"https://out.reddit.com/t3_1abcde?url=https%3A%2F%2Fexample.com%2Freddit&token=x&app_name=web": "https://example.com/reddit",
+		"https://example.com/plain": "https://example.com/plain",
+	}
+	for link, want := range links {
+		if got := unwrapRedirector(context.Background(), link); got != want {
+			t.Errorf("unwrapRedirector(%q) = %q, want %q", link, got, want)
+		}
+	}
+}
+
+func TestUnresolvedLinksReported(t *testing.T) {
+	fake := setupFakeDiscord(t)
+
+	items := resolveItemLinks(context.Background(), testFeedUrl, []discordMessageData{
+		{ID: "linked", Title: "Linked", Link: "https://example.com/1"},
+		{ID: "unlinked", Title: "No link here"},
+		{ID: "unlinked-edit", Title: "Edited without a link", Updated: true},
+	})
+	if len(items) != 1 || items[0].ID != "linked" {
+		t.Errorf("expected only the linked item to be kept, got %+v", items)
+	}
+
+	reports := fake.sentTo(testAdminChannel)
+	if len(reports) != 1 || !strings.Contains(reports[0].Message.Content, "No link here") || strings.Contains(reports[0].Message.Content, "Edited") {
+		t.Errorf("expected the new unlinked item to be reported to the admins, got %+v", reports)
+	}
+}
+
+func TestLinksOnlyResolvedForPostedItems(t *testing.T) {
+	fake := setupFakeDiscord(t)
+	fetches := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fetches++
+		http.Redirect(w, r, "https://example.com/article", http.StatusFound)
+	}))
+	defer server.Close()
+	serverUrl, _ := url.Parse(server.URL)
+	redirectorHosts[serverUrl.Host] = true
+	defer delete(redirectorHosts, serverUrl.Host)
+	if err := store.saveFeed(feedConfig{URL: testFeedUrl, Type: "zdi", Topics: []string{"ransomware"}}); err != nil {
+		t.Fatal(err)
+	}
+
+	postPollItems(context.Background(), testFeedUrl, []discordMessageData{
+		{ID: "old", Title: "Old ransomware strain", Published: time.Now().AddDate(-1, 0, 0)},
+		{ID: "off-topic", Title: "Company announces new CEO", Link: server.URL + "/off-topic"},
+		{ID: "posted", Title: "New ransomware strain", Link: server.URL + "/posted"},
+	})
+	if fetches != 1 {
+		t.Errorf("expected only the posted item's redirector to be fetched, got %d fetches", fetches)
+	}
+	if reports := fake.sentTo(testAdminChannel); len(reports) != 0 {
+		t.Errorf("a dropped item was reported for its missing link, got %+v", reports)
+	}
+	if news := fake.sentTo(testNewsChannel); len(news) != 1 || news[0].Message.Embeds[0].Fields[0].Value != "https://example.com/article" {
+		t.Errorf("expected the one item posted with its unwrapped link, got %+v", news)
+	}
+}
@@ -0,0 +1,106 @@
+/*
+Logging setup. Everything logs through log/slog, configured from the LOG_LEVEL and LOG_FORMAT environment variables,
+and any secrets the bot knows about are scrubbed from log output before it's written.
+*/
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"strings"
+)
+
+const redacted = "[REDACTED]"
+
+// redactingHandler wraps another handler, replacing secrets in the message and in every attribute
+type redactingHandler struct {
+	slog.Handler
+	secrets []string
+}
+
+func (h redactingHandler) redact(text string) string {
+	for _, secret := range h.secrets {
+		text = strings.ReplaceAll(text, secret, redacted)
+	}
+	return text
+}
+
+func (h redactingHandler) redactAttr(attr slog.Attr) slog.Attr {
+	value := attr.Value.Resolve()
+	switch value.Kind() {
+	case slog.KindString:
+		return slog.String(attr.Key, h.redact(value.String()))
+	case slog.KindGroup:
+		attrs := value.Group()
+		redactedAttrs := make([]any, 0, len(attrs))
+		for _, groupAttr := range attrs {
+			redactedAttrs = append(redactedAttrs, h.redactAttr(groupAttr))
+		}
+		return slog.Group(attr.Key, redactedAttrs...)
+	case slog.KindAny:
+		// errors and anything else printable may well contain a URL or header with the token in it
+		return slog.String(attr.Key, h.redact(fmt.Sprint(value.Any())))
+	}
+	return attr
+}
+
+func (h redactingHandler) Handle(ctx context.Context, record slog.Record) error {
+	cleaned := slog.NewRecord(record.Time, record.Level, h.redact(record.Message), record.PC)
+	record.Attrs(func(attr slog.Attr) bool {
+		cleaned.AddAttrs(h.redactAttr(attr))
+		return true
+	})
+	return h.Handler.Handle(ctx, cleaned)
+}
+
+func (h redactingHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	cleaned := make([]slog.Attr, 0, len(attrs))
+	for _, attr := range attrs {
+		cleaned = append(cleaned, h.redactAttr(attr))
+	}
+	return redactingHandler{Handler: h.Handler.WithAttrs(cleaned), secrets: h.secrets}
+}
+
+func (h redactingHandler) WithGroup(name string) slog.Handler {
+	return redactingHandler{Handler: h.Handler.WithGroup(name), secrets: h.secrets}
+}
+
+func setupLogging(level string, format string, secrets ...string) error {
+	/*
+		Install the default slog logger. The standard log package, which discordgo logs through, is routed to the
+		same handler by slog.SetDefault.
+	*/
+	var logLevel slog.Level
+	if level != "" {
+		if err := logLevel.UnmarshalText([]byte(level)); err != nil {
+			return fmt.Errorf("err: unknown log level '%v'", level)
+		}
+	}
+
+	options := &slog.HandlerOptions{Level: logLevel}
+	var handler slog.Handler
+	switch strings.ToLower(format) {
+	case "", "text":
+		handler = slog.NewTextHandler(os.Stderr, options)
+	case "json":
+		handler = slog.NewJSONHandler(os.Stderr, options)
+	default:
+		return fmt.Errorf("err: unknown log format '%v'", format)
+	}
+
+	nonEmpty := make([]string, 0, len(secrets))
+	for _, secret := range secrets {
+		if secret != "" {
+			nonEmpty = append(nonEmpty, secret)
+		}
+	}
+	slog.SetDefault(slog.New(redactingHandler{Handler: handler, secrets: nonEmpty}))
+	return nil
+}
+
+func fatal(msg string, args ...any) {
+	slog.Error(msg, args...)
+	os.Exit(1)
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"log/slog"
+	"strings"
+	"testing"
+)
+
+const testToken = "MTAxNjU4.secret-bot-token"
+
+// tokenURL stands in for a value that only shows the token once it's formatted, like a request
+type tokenURL struct{}
+
+func (tokenURL) String() string { return "https://discord.com/api?token=" + testToken }
+
+func TestRedactingHandler(t *testing.T) {
+	cases := []struct {
+		name string
+		log  func(logger *slog.Logger)
+	}{
+		{"message", func(logger *slog.Logger) { logger.Info("connecting with " + testToken) }},
+		{"string attr", func(logger *slog.Logger) { logger.Info("connecting", "token", testToken) }},
+		{"error attr", func(logger *slog.Logger) { logger.Error("connecting", "err", errors.New("bad token "+testToken)) }},
+		{"stringer attr", func(logger *slog.Logger) { logger.Info("request", "url", tokenURL{}) }},
+		{"nested group", func(logger *slog.Logger) {
+			logger.Info("request", slog.Group("http", slog.Group("headers", slog.String("Authorization", "Bot "+testToken))))
+		}},
+		{"with attrs", func(logger *slog.Logger) { logger.With("token", testToken).Info("connecting") }},
+		{"with group", func(logger *slog.Logger) {
+			logger.WithGroup("session").With("token", testToken).Info("connecting", "err", errors.New(testToken))
+		}},
+		{"standard log package", func(logger *slog.Logger) {
+			// discordgo logs through the log package, which slog.SetDefault routes through the same handler
+			slog.NewLogLogger(logger.Handler(), slog.LevelInfo).Printf("[DG0] token %s", testToken)
+		}},
+	}
+	for _, c := range cases {
+		for _, format := range []string{"text", "json"} {
+			var output bytes.Buffer
+			var handler slog.Handler = slog.NewTextHandler(&output, nil)
+			if format == "json" {
+				handler = slog.NewJSONHandler(&output, nil)
+			}
+			c.log(slog.New(redactingHandler{Handler: handler, secrets: []string{testToken}}))
+
+			if strings.Contains(output.String(), testToken) || !strings.Contains(output.String(), redacted) {
+				t.Errorf("%s (%s): token not redacted in %q", c.name, format, output.String())
+			}
+		}
+	}
+}
+
+func TestRedactingHandlerKeepsOtherValues(t *testing.T) {
+	var output bytes.Buffer
+	logger := slog.New(redactingHandler{Handler: slog.NewTextHandler(&output, nil), secrets: []string{testToken}})
+	logger.WithGroup("feed").Info("polled", "items", 3, "ok", true, "url", "https://example.com/feed")
+
+	want := "feed.items=3 feed.ok=true feed.url=https://example.com/feed"
+	if !strings.Contains(output.String(), want) {
+		t.Errorf("expected %q in %q", want, output.String())
+	}
+}
+
+func TestSetupLoggingRefusesUnknownSettings(t *testing.T) {
+	if err := setupLogging("loud", "text"); err == nil {
+		t.Error("expected an unknown level to be refused")
+	}
+	if err := setupLogging("info", "xml"); err == nil {
+		t.Error("expected an unknown format to be refused")
+	}
+}
@@ -2,29 +2,42 @@ package main
 
 import (
 	"bytes"
+	"context"
 	"crypto/sha256"
-	"encoding/xml"
-	"log"
+	"fmt"
+	"log/slog"
 	"os"
-	"sync"
+	"os/signal"
+	"strconv"
+	"syscall"
 	"time"
 
 	"github.com/bwmarrin/discordgo"
 )
 
 // generators for feed structures. Code can generate new RSS structures
-// based on the feeds URL
+// based on the type of feed, which is how feeds are referred to in the
+// state file and the /feed commands
 type RSSFeedFactory func() RSSFeed
 
-var rssFeeds = map[string]RSSFeedFactory{
-	"https://googleprojectzero.blogspot.com/feeds/posts/default": func() RSSFeed { return &ProjectZeroRssFeed{} },
-	"https://feeds.feedburner.com/TheHackersNews":                func() RSSFeed { return &HackerNewsRssFeed{} },
-	"https://www.zerodayinitiative.com/blog?format=rss":          func() RSSFeed { return &ZDIRssFeed{} },
-	"https://portswigger.net/research/rss":                       func() RSSFeed { return &PortSwiggerRSSFeed{} },
-	// "http://127.0.0.1:8081/rss_tests/hackernews/xmlfeed.xml":             func() RSSFeed { return &HackerNewsRssFeed{} },
-	// "http://127.0.0.1:8081/rss_tests/project_zero/newgooglefeed.xml":     func() RSSFeed { return &ProjectZeroRssFeed{} },
-	// "http://127.0.0.1:8081/rss_tests/zdi/feed.xml":                       func() RSSFeed { return &ZDIRssFeed{} },
-	// "http://127.0.0.1:8081/rss_tests/portswigger/feed.xml":               func() RSSFeed { return &PortSwiggerRSSFeed{} },
+var feedTypes = map[string]RSSFeedFactory{
+	"projectzero": func() RSSFeed { return &ProjectZeroRssFeed{} },
+	"hackernews":  func() RSSFeed { return &HackerNewsRssFeed{} },
+	"zdi":         func() RSSFeed { return &ZDIRssFeed{} },
+	"portswigger": func() RSSFeed { return &PortSwiggerRSSFeed{} },
+}
+
+// feeds monitored the first time the bot starts. After that, the feed list
+// lives in the state file and is managed with /feed
+var defaultFeeds = map[string]string{
+	"https://googleprojectzero.blogspot.com/feeds/posts/default": "projectzero",
+	"https://feeds.feedburner.com/TheHackersNews":                "hackernews",
+	"https://www.zerodayinitiative.com/blog?format=rss":          "zdi",
+	"https://portswigger.net/research/rss":                       "portswigger",
+	// served by mock-outlet scenarios/example.json
+	// "http://127.0.0.1:8081/hackernews":  "hackernews",
+	// "http://127.0.0.1:8081/zdi":         "zdi",
+	// "http://127.0.0.1:8081/portswigger": "portswigger",
 }
 
 const (
@@ -41,69 +54,116 @@ var (
 	adminChannelId       string
 )
 
-func rssPollLoop(feedUrl string) {
+func (monitor *feedMonitor) poll() {
+	/*
+		Fetch the feed once and post anything new since the last poll. The first successful poll just records a
+		baseline to compare later polls against. Only called by the scheduler, which never polls a feed twice at
+		once, so the baseline needs no locking.
+	*/
 	// TODO: Pointless to store the entire RSS feed. After unmarshalling we could just keep the most recent 20 results or something
-	var (
-		pageHash        []byte
-		oldPageHash     []byte
-		pageContents    []byte
-		oldPageContents []byte
-		oldPageXmlData  RSSFeed
-		pageXmlData     RSSFeed
-	)
+	feedUrl := monitor.url
 
-	oldPageContents, err := queryRssFeed(feedUrl)
-	oldPageHash, err = getPageHash(oldPageContents)
+	start := time.Now()
+	pageContents, statusCode, err := queryRssFeed(monitor.ctx, feedUrl)
+	metricFetchDuration.observeSince(start, feedUrl)
+	metricFetchStatus.inc(feedUrl, strconv.Itoa(statusCode))
+	metricBytesDownloaded.add(float64(len(pageContents)), feedUrl)
+	if monitor.ctx.Err() != nil {
+		// cancelled part way through the request, not the feed's fault
+		return
+	} else if err != nil {
+		monitor.log.Warn("fetching feed, will retry", "err", err)
+		monitor.recordPoll(nil, err)
+		return
+	}
+
+	pageHash, err := getPageHash(pageContents)
 	if err != nil {
-		log.Panicln("err:", err.Error())
+		monitor.log.Warn("hashing feed, will retry", "err", err)
+		monitor.recordPoll(nil, err)
+		return
 	}
-	log.Printf("starting with page hash %x for site %s", oldPageHash, feedUrl)
 
-	oldPageXmlData = rssFeeds[feedUrl]()
-	pageXmlData = rssFeeds[feedUrl]()
+	if monitor.baseline != nil && bytes.Equal(monitor.baselineHash, pageHash) {
+		monitor.log.Debug("feed unchanged", "hash", fmt.Sprintf("%x", pageHash))
+		metricUnchanged.inc(feedUrl)
+		monitor.recordPoll(monitor.baseline, nil)
+		return
+	}
 
-	if err := xml.Unmarshal(oldPageContents, &oldPageXmlData); err != nil {
+	pageXmlData, err := unmarshalFeed(pageContents, monitor.newFeed)
+	if err != nil {
 		// mostly occurs when the page struct does not represent the XML data closely enough
-		log.Panicln("err unmarshaling XML:", err)
+		monitor.log.Error("unmarshaling XML, stopping monitor", "err", err)
+		metricParseErrors.inc(feedUrl)
+		monitor.recordPoll(nil, err)
+		monitor.markStopped()
 		return
 	}
-	for {
-		time.Sleep(pollFreq * time.Minute)
-		// time.Sleep(5 * time.Second)
-		pageContents, err = queryRssFeed(feedUrl)
-		if err != nil {
-			log.Printf("err: %v. Continuing\n", err)
-			continue
-		}
 
-		pageHash, err = getPageHash(pageContents)
-		if err != nil {
-			log.Panicln("err:", err.Error())
+	if monitor.baseline == nil {
+		monitor.log.Info("starting monitor", "hash", fmt.Sprintf("%x", pageHash))
+		monitor.backfill(pageXmlData)
+	} else {
+		newRssContent, err := parseNewRssContent(monitor.ctx, monitor.baseline, pageXmlData)
+		if monitor.ctx.Err() != nil {
+			return
+		} else if err != nil {
+			monitor.log.Error("parsing feed, stopping monitor", "err", err)
+			metricParseErrors.inc(feedUrl)
+			monitor.recordPoll(nil, err)
+			monitor.markStopped()
+			return
 		}
-
-		if bytes.Equal(oldPageHash, pageHash) {
-			log.Printf("hash for '%v' is the same. Sleeping", feedUrl)
-			continue
+		if recordItemMetrics(feedUrl, monitor.baseline, pageXmlData, newRssContent) > 0 {
+			monitor.markNewItems()
 		}
 
-		if err := xml.Unmarshal(pageContents, &pageXmlData); err != nil {
-			log.Printf("err: unmarshaling XML - %v\tStopping monitor\n", err)
-			break
+		monitor.log.Info("feed changed", "items", len(newRssContent))
+		for idx := range newRssContent {
+			newRssContent[idx].Source = monitor.feedType
 		}
+		postPollItems(monitor.ctx, feedUrl, newRssContent)
+	}
 
-		newRssContent, err := parseNewRssContent(oldPageXmlData, pageXmlData)
-		if err != nil {
-			log.Printf("err: parsing RSS feed '%v'\tStopping monitor\n", newRssContent)
-			break
-		}
+	monitor.recordPoll(pageXmlData, nil)
+	// the new data replaces the old for future polls
+	monitor.baselineHash = pageHash
+	monitor.baseline = pageXmlData
+}
+
+func postPollItems(ctx context.Context, feedUrl string, items []discordMessageData) {
+	/*
+		Filter a poll's changed items down to what should be posted, then post it. Links are only resolved for items
+		that made it through, as that can mean fetching a redirector or telling the admins about a missing link.
+	*/
+	items = filterTopics(feedUrl, applyUpdatePolicy(feedUrl, dropOldItems(items)))
+	items = holdBurst(feedUrl, items)
+	submitNewRssContent(resolveItemLinks(ctx, feedUrl, items))
+}
 
-		submitNewRssContent(newRssContent)
-		// the new data replaces the old for future iterations
-		oldPageHash = pageHash
-		oldPageContents = pageContents
-		oldPageXmlData = pageXmlData
-		pageXmlData = rssFeeds[feedUrl]()
+func (monitor *feedMonitor) backfill(feed RSSFeed) {
+	/*
+		The first time a feed is ever polled, post whatever its backfill policy asks for from the first snapshot
+	*/
+	feedConfig, first, err := store.markBackfilled(monitor.url)
+	if err != nil {
+		monitor.log.Error("saving state", "err", err)
 	}
+	if !first {
+		return
+	}
+
+	items := feedConfig.backfillItems(feed.messageData())
+	if len(items) == 0 {
+		return
+	}
+	monitor.log.Info("backfilling feed", "policy", feedConfig.Backfill, "items", len(items))
+	for idx := range items {
+		items[idx].Source = monitor.feedType
+	}
+	items = holdBurst(monitor.url, filterTopics(monitor.url, items))
+	submitNewRssContent(resolveItemLinks(monitor.ctx, monitor.url, items))
 }
 
 func getPageHash(pageBody []byte) (pageHash []byte, errorString error) {
@@ -113,27 +173,28 @@ func getPageHash(pageBody []byte) (pageHash []byte, errorString error) {
 	hasher := sha256.New()
 	hasher.Write(pageBody)
 	pageHash = hasher.Sum(nil)
-	log.Printf("Hash for webpage was: %x\n", pageHash)
 	return
 }
 
 func startPollingRss() {
-	// hacky way to stop the program from exiting after creating all of the goroutines
-	// will idle until all of the goroutines returns
-	var wg sync.WaitGroup
-	wg.Add(len(rssFeeds))
-	for rssFeed := range rssFeeds {
-		go func(url string) {
-			defer wg.Done()
-			rssPollLoop(url)
-		}(rssFeed)
-	}
-	wg.Wait()
+	/*
+		Start a monitor for every feed in the state file, seeding the state with the default feeds the first time the
+		bot runs.
+	*/
+	if err := store.seedFeeds(defaultFeeds); err != nil {
+		fatal("saving default feeds", "err", err)
+	}
+	for _, feed := range store.listFeeds() {
+		if _, err := scheduler.add(feed); err != nil {
+			slog.Error("starting monitor", "feed", feed.URL, "err", err)
+		}
+	}
 }
 
 func setCommitteeRoles(roles []*discordgo.Role) bool {
 	/*
-		Find the role IDs for the committee and prior committee roles.
+		Find the role IDs for the committee and prior committee roles. Neither has to exist, they're only the
+		default holders of the bot's capabilities until /permissions is used.
 	*/
 	for _, role := range roles {
 		if role.Name == "Committee" {
@@ -149,56 +210,68 @@ func setCommitteeRoles(roles []*discordgo.Role) bool {
 }
 
 func main() {
+	os.Exit(runCli(os.Args[1:]))
+}
+
+func runBot(cfg botConfig) {
+	/*
+		Connect to Discord and poll the feeds until we're asked to stop
+	*/
 	var (
 		err   error
 		roles []*discordgo.Role
 	)
 
-	discordToken = os.Getenv("DISCORD_BOT_TOKEN")
-	newsChannelId = os.Getenv("DISCORD_CHANNEL_ID")
-	adminChannelId = os.Getenv("ADMIN_CHANNEL_ID")
-	serverId = os.Getenv("DISCORD_SERVER_ID")
-
-	if len(discordToken) < 1 || len(newsChannelId) < 1 {
-		log.Fatalln("err: reading env vars")
+	cfg.apply()
+	if store, err = loadStateStore(cfg.StateFile); err != nil {
+		fatal("loading state file", "path", cfg.StateFile, "err", err)
 	}
 
+	var stop context.CancelFunc
+	botContext, stop = signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	scheduler = newFeedScheduler(botContext, cfg.PollInterval, cfg.MaxFetches)
+
+	server := startHTTPServer(cfg.HTTPAddr)
+
 	if discordSession, err = discordgo.New("Bot " + discordToken); err != nil {
-		log.Fatalln("err: creating Discord session")
+		fatal("creating Discord session", "err", err)
 	}
 
 	discordSession.AddHandlerOnce(func(session *discordgo.Session, event *discordgo.Ready) {
-		log.Println("Bot is connected and ready.")
-	})
-	discordSession.AddHandler(discordMessageHandler)
-	discordSession.AddHandler(func(s *discordgo.Session, i *discordgo.InteractionCreate) {
-		if h, ok := commandHandlers[i.ApplicationCommandData().Name]; ok {
-			h(s, i)
-		}
+		slog.Info("bot is connected and ready")
 	})
+	trackGatewayState(discordSession)
+	discord = sessionClient{session: discordSession}
+	discordSession.AddHandler(func(s *discordgo.Session, m *discordgo.MessageCreate) { discordMessageHandler(discord, m) })
+	discordSession.AddHandler(func(s *discordgo.Session, i *discordgo.InteractionCreate) { interactionHandler(discord, i) })
 
 	if err = discordSession.Open(); err != nil {
-		log.Fatalln("err: opening connection to Discord")
+		fatal("opening connection to Discord", "err", err)
 	}
 
-	if roles, err = discordSession.GuildRoles(serverId); err != nil {
-		log.Fatalln("err: retrieving roles:")
+	if roles, err = discord.guildRoles(serverId); err != nil {
+		slog.Error("retrieving roles", "guild", serverId, "err", err)
 	}
 
 	if !setCommitteeRoles(roles) {
-		log.Fatalln("err: retrieving roles:")
+		slog.Warn("committee roles not found, only server admins can use the bot until /permissions is configured")
 	}
 
 	registeredCommands := make([]*discordgo.ApplicationCommand, len(discordCommands))
 	for i, v := range discordCommands {
 		cmd, err := discordSession.ApplicationCommandCreate(discordSession.State.User.ID, serverId, v)
 		if err != nil {
-			log.Panicln("err: creating application command")
+			fatal("creating application command", "command", v.Name, "err", err)
 		}
 		registeredCommands[i] = cmd
 	}
 
-	defer discordSession.Close()
-	log.Println("News polling started")
+	slog.Info("news polling started")
 	startPollingRss()
+
+	// the monitors and Discord handlers do the work until we're asked to stop
+	<-botContext.Done()
+	stop()
+	shutdown(cfg.ShutdownTimeout, server, registeredCommands, cfg.DeregisterCommands)
 }
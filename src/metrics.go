@@ -0,0 +1,225 @@
+/*
+Metrics for polling and delivery health, served in the Prometheus text format on /metrics. The bot only needs
+counters, gauges and histograms with a handful of labels, so they're implemented here rather than pulling in a client
+library.
+*/
+package main
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const metricsPrefix = "newsbot_"
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// metricVec is a counter or gauge, one value per combination of label values
+type metricVec struct {
+	name   string
+	help   string
+	kind   string
+	labels []string
+
+	mu     sync.Mutex
+	values map[string]float64
+}
+
+// histogramVec counts observations into cumulative buckets, one histogram per combination of label values
+type histogramVec struct {
+	name    string
+	help    string
+	labels  []string
+	buckets []float64
+
+	mu     sync.Mutex
+	counts map[string][]uint64
+	sums   map[string]float64
+	totals map[string]uint64
+}
+
+// anything that can write itself out for /metrics
+type metricWriter interface {
+	write(w io.Writer)
+}
+
+var metricsRegistry []metricWriter
+
+func newCounter(name string, help string, labels ...string) *metricVec {
+	metric := &metricVec{name: metricsPrefix + name, help: help, kind: "counter", labels: labels, values: map[string]float64{}}
+	metricsRegistry = append(metricsRegistry, metric)
+	return metric
+}
+
+func newGauge(name string, help string, labels ...string) *metricVec {
+	metric := newCounter(name, help, labels...)
+	metric.kind = "gauge"
+	return metric
+}
+
+func newHistogram(name string, help string, buckets []float64, labels ...string) *histogramVec {
+	metric := &histogramVec{
+		name:    metricsPrefix + name,
+		help:    help,
+		labels:  labels,
+		buckets: buckets,
+		counts:  map[string][]uint64{},
+		sums:    map[string]float64{},
+		totals:  map[string]uint64{},
+	}
+	metricsRegistry = append(metricsRegistry, metric)
+	return metric
+}
+
+var (
+	metricPolls              = newCounter("feed_polls_total", "Polls of each feed, including failed ones", "feed")
+	metricFetchDuration      = newHistogram("feed_fetch_duration_seconds", "Time taken to fetch each feed", []float64{0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30}, "feed")
+	metricFetchStatus        = newCounter("feed_fetch_responses_total", "HTTP responses from each feed by status code, 0 when the request failed", "feed", "code")
+	metricBytesDownloaded    = newCounter("feed_bytes_downloaded_total", "Bytes of feed content downloaded", "feed")
+	metricUnchanged          = newCounter("feed_unchanged_total", "Polls where the feed hash hadn't changed", "feed")
+	metricParseErrors        = newCounter("feed_parse_errors_total", "Polls where the feed couldn't be unmarshaled or parsed", "feed")
+	metricNewItems           = newCounter("feed_new_items_total", "New items found in each feed", "feed")
+	metricFilteredItems      = newCounter("feed_filtered_items_total", "New items the feed's parser decided not to post", "feed")
+	metricDiscordSends       = newCounter("discord_sends_total", "Messages sent to the news channel", "result")
+	metricQueueDepth         = newGauge("discord_queue_depth", "Items waiting to be posted to the news channel")
+	metricLastSuccessfulPoll = newGauge("feed_last_success_timestamp_seconds", "Unix time of each feed's last successful poll", "feed")
+)
+
+func labelKey(values []string) string {
+	return strings.Join(values, "\x00")
+}
+
+func (m *metricVec) add(delta float64, labelValues ...string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.values[labelKey(labelValues)] += delta
+}
+
+func (m *metricVec) inc(labelValues ...string) {
+	m.add(1, labelValues...)
+}
+
+func (m *metricVec) set(value float64, labelValues ...string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.values[labelKey(labelValues)] = value
+}
+
+func (h *histogramVec) observe(value float64, labelValues ...string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	key := labelKey(labelValues)
+	if h.counts[key] == nil {
+		h.counts[key] = make([]uint64, len(h.buckets))
+	}
+	for idx, bound := range h.buckets {
+		if value <= bound {
+			h.counts[key][idx]++
+		}
+	}
+	h.sums[key] += value
+	h.totals[key]++
+}
+
+func (h *histogramVec) observeSince(start time.Time, labelValues ...string) {
+	h.observe(time.Since(start).Seconds(), labelValues...)
+}
+
+func formatLabels(names []string, key string, extra ...string) string {
+	/*
+		Render {name="value",...} for a label key, with any extra name/value pairs (like a histogram's le) on the end
+	*/
+	var values []string
+	if len(names) > 0 {
+		values = strings.Split(key, "\x00")
+	}
+	pairs := make([]string, 0, len(names)+len(extra)/2)
+	for idx, name := range names {
+		pairs = append(pairs, fmt.Sprintf(`%s="%s"`, name, labelEscaper.Replace(values[idx])))
+	}
+	for idx := 0; idx+1 < len(extra); idx += 2 {
+		pairs = append(pairs, fmt.Sprintf(`%s="%s"`, extra[idx], labelEscaper.Replace(extra[idx+1])))
+	}
+	if len(pairs) == 0 {
+		return ""
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+func formatFloat(value float64) string {
+	if math.IsInf(value, 1) {
+		return "+Inf"
+	}
+	return strconv.FormatFloat(value, 'g', -1, 64)
+}
+
+func sortedKeys[V any](values map[string]V) []string {
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func (m *metricVec) write(w io.Writer) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", m.name, m.help, m.name, m.kind)
+	if len(m.labels) == 0 && len(m.values) == 0 {
+		fmt.Fprintf(w, "%s 0\n", m.name)
+	}
+	for _, key := range sortedKeys(m.values) {
+		fmt.Fprintf(w, "%s%s %s\n", m.name, formatLabels(m.labels, key), formatFloat(m.values[key]))
+	}
+}
+
+func (h *histogramVec) write(w io.Writer) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s histogram\n", h.name, h.help, h.name)
+	for _, key := range sortedKeys(h.totals) {
+		for idx, bound := range h.buckets {
+			fmt.Fprintf(w, "%s_bucket%s %d\n", h.name, formatLabels(h.labels, key, "le", formatFloat(bound)), h.counts[key][idx])
+		}
+		fmt.Fprintf(w, "%s_bucket%s %d\n", h.name, formatLabels(h.labels, key, "le", "+Inf"), h.totals[key])
+		fmt.Fprintf(w, "%s_sum%s %s\n", h.name, formatLabels(h.labels, key), formatFloat(h.sums[key]))
+		fmt.Fprintf(w, "%s_count%s %d\n", h.name, formatLabels(h.labels, key), h.totals[key])
+	}
+}
+
+func recordItemMetrics(feedUrl string, oldData RSSFeed, newData RSSFeed, posted []discordMessageData) int {
+	/*
+		Count the items that are new to the feed, and how many of those the parser filtered out rather than
+		returning for posting. Returns the number of new items.
+	*/
+	added, _ := diffRssItems(oldData.messageData(), newData.messageData())
+	kept := 0
+	for _, item := range posted {
+		if !item.Updated {
+			kept++
+		}
+	}
+	metricNewItems.add(float64(len(added)), feedUrl)
+	if filtered := len(added) - kept; filtered > 0 {
+		metricFilteredItems.add(float64(filtered), feedUrl)
+	}
+	return len(added)
+}
+
+func metricsHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	for _, metric := range metricsRegistry {
+		metric.write(w)
+	}
+}
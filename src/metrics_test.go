@@ -0,0 +1,82 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMetricsExposition(t *testing.T) {
+	counter := &metricVec{name: "test_total", help: "A counter", kind: "counter", labels: []string{"feed", "code"}, values: map[string]float64{}}
+	counter.inc("https://example.com/b", "200")
+	counter.add(2, "https://example.com/a", "0")
+	counter.inc(`say "hi"\now`+"\n", "500")
+	gauge := &metricVec{name: "test_depth", help: "An unlabelled gauge", kind: "gauge", values: map[string]float64{}}
+	histogram := &histogramVec{name: "test_seconds", help: "A histogram", labels: []string{"feed"}, buckets: []float64{0.5, 1},
+		counts: map[string][]uint64{}, sums: map[string]float64{}, totals: map[string]uint64{}}
+	histogram.observe(0.25, "a")
+	histogram.observe(0.75, "a")
+	histogram.observe(3, "a")
+
+	var output bytes.Buffer
+	for _, metric := range []metricWriter{counter, gauge, histogram} {
+		metric.write(&output)
+	}
+	want := `# HELP test_total A counter
+# TYPE test_total counter
+test_total{feed="https://example.com/a",code="0"} 2
+test_total{feed="https://example.com/b",code="200"} 1
+test_total{feed="say \"hi\"\\now\n",code="500"} 1
+# HELP test_depth An unlabelled gauge
+# TYPE test_depth gauge
+test_depth 0
+# HELP test_seconds A histogram
+# TYPE test_seconds histogram
+test_seconds_bucket{feed="a",le="0.5"} 1
+test_seconds_bucket{feed="a",le="1"} 2
+test_seconds_bucket{feed="a",le="+Inf"} 3
+test_seconds_sum{feed="a"} 4
+test_seconds_count{feed="a"} 3
+`
+	if output.String() != want {
+		t.Errorf("got\n%s\nwant\n%s", output.String(), want)
+	}
+}
+
+func hasFeedMetrics(feedUrl string) bool {
+	metricFetchStatus.mu.Lock()
+	defer metricFetchStatus.mu.Unlock()
+	for key := range metricFetchStatus.values {
+		if strings.HasPrefix(key, feedUrl+"\x00") {
+			return true
+		}
+	}
+	return false
+}
+
+func TestFetchMetricsOnlyForMonitoredFeeds(t *testing.T) {
+	setupFakeDiscord(t)
+	outlet := startTestOutlet(t)
+
+	// trying out a URL, as /feed test and /feed add do, doesn't give it metrics of its own
+	tried := outlet.URL + "/tried"
+	if _, err := fetchFeed(context.Background(), tried, feedTypes["zdi"]); err != nil {
+		t.Fatal(err)
+	}
+	if hasFeedMetrics(tried) {
+		t.Error("a one-off fetch was recorded in the feed metrics")
+	}
+
+	monitored := outlet.URL + "/monitored"
+	sched := startTestScheduler(t, 1)
+	if _, err := sched.add(feedConfig{URL: monitored, Type: "zdi"}); err != nil {
+		t.Fatal(err)
+	}
+	for deadline := time.Now().Add(5 * time.Second); !hasFeedMetrics(monitored); time.Sleep(10 * time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatal("a monitored feed's fetch wasn't recorded")
+		}
+	}
+}
@@ -0,0 +1,497 @@
+/*
+A mock news outlet, for running the whole bot against feeds we control. A scenario file describes each feed and a
+timeline of what happens to it: articles published, edited and deleted, and the server rate limiting, failing, stalling
+or sending broken XML. Steps fire a set time after startup or on a set request for the feed, so runs are repeatable.
+*/
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+const (
+	mockPublish   = "publish"
+	mockEdit      = "edit"
+	mockDelete    = "delete"
+	mockLoad      = "load"
+	mockStatus    = "status"
+	mockSlow      = "slow"
+	mockMalformed = "malformed"
+)
+
+// feed types whose parser reads Atom rather than RSS
+var atomFeedTypes = map[string]bool{"projectzero": true}
+
+// scenarioDuration is a duration written like "30s" in the scenario file
+type scenarioDuration time.Duration
+
+func (d *scenarioDuration) UnmarshalJSON(data []byte) error {
+	var text string
+	if err := json.Unmarshal(data, &text); err != nil {
+		return err
+	}
+	parsed, err := time.ParseDuration(text)
+	if err != nil {
+		return err
+	}
+	*d = scenarioDuration(parsed)
+	return nil
+}
+
+type mockScenario struct {
+	Feeds []mockFeedScript `json:"feeds"`
+}
+
+type mockFeedScript struct {
+	Path  string     `json:"path"`
+	Type  string     `json:"type"`
+	Title string     `json:"title"`
+	Seed  string     `json:"seed"`
+	Items []mockItem `json:"items"`
+	Steps []mockStep `json:"steps"`
+}
+
+type mockItem struct {
+	ID          string    `json:"id"`
+	Title       string    `json:"title"`
+	Description string    `json:"description"`
+	Link        string    `json:"link"`
+	Categories  []string  `json:"categories"`
+	Published   time.Time `json:"published"`
+}
+
+// mockStep happens once the feed has been up for At, or on its Request'th request if that's set
+type mockStep struct {
+	At      scenarioDuration `json:"at"`
+	Request int              `json:"request"`
+	Action  string           `json:"action"`
+	Item    mockItem         `json:"item"`
+	File    string           `json:"file"`
+	Status  int              `json:"status"`
+	Delay   scenarioDuration `json:"delay"`
+	Count   int              `json:"count"`
+}
+
+// mockFault is misbehaviour applied to the next few requests for a feed
+type mockFault struct {
+	action    string
+	status    int
+	delay     time.Duration
+	remaining int
+}
+
+type mockFeed struct {
+	script mockFeedScript
+
+	mu       sync.Mutex
+	requests int
+	items    []mockItem
+	pending  []mockStep
+	fault    *mockFault
+}
+
+type mockOutlet struct {
+	started time.Time
+	feeds   map[string]*mockFeed
+}
+
+func loadMockScenario(path string) (mockScenario, error) {
+	var scenario mockScenario
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return scenario, err
+	}
+	if err = json.Unmarshal(data, &scenario); err != nil {
+		return scenario, fmt.Errorf("err: reading scenario %v - %v", path, err)
+	}
+
+	// saved feeds are found relative to the scenario, wherever the command is run from
+	relative := func(file string) string {
+		if file == "" || filepath.IsAbs(file) {
+			return file
+		}
+		return filepath.Join(filepath.Dir(path), file)
+	}
+	for idx := range scenario.Feeds {
+		scenario.Feeds[idx].Seed = relative(scenario.Feeds[idx].Seed)
+		for stepIdx := range scenario.Feeds[idx].Steps {
+			scenario.Feeds[idx].Steps[stepIdx].File = relative(scenario.Feeds[idx].Steps[stepIdx].File)
+		}
+	}
+	return scenario, nil
+}
+
+func newMockOutlet(scenario mockScenario) (*mockOutlet, error) {
+	/*
+		Check the whole scenario up front, so a typo fails at startup rather than halfway through a run
+	*/
+	outlet := &mockOutlet{started: time.Now(), feeds: map[string]*mockFeed{}}
+	if len(scenario.Feeds) == 0 {
+		return nil, errors.New("err: the scenario has no feeds")
+	}
+
+	for _, script := range scenario.Feeds {
+		if !strings.HasPrefix(script.Path, "/") || strings.HasPrefix(script.Path, "/articles/") {
+			return nil, fmt.Errorf("err: feed path '%v' must start with / and not be under /articles/", script.Path)
+		}
+		if _, ok := outlet.feeds[script.Path]; ok {
+			return nil, fmt.Errorf("err: more than one feed at %v", script.Path)
+		}
+		if _, ok := feedTypes[script.Type]; !ok {
+			return nil, fmt.Errorf("err: feed %v has unknown type '%v'", script.Path, script.Type)
+		}
+
+		feed := &mockFeed{script: script, items: append([]mockItem(nil), script.Items...), pending: script.Steps}
+		if script.Seed != "" {
+			items, err := mockItemsFromFile(script.Seed, script.Type)
+			if err != nil {
+				return nil, err
+			}
+			feed.items = append(feed.items, items...)
+		}
+		for idx, step := range script.Steps {
+			if err := step.validate(script.Type); err != nil {
+				return nil, fmt.Errorf("err: step %d of %v - %v", idx+1, script.Path, err)
+			}
+		}
+		outlet.feeds[script.Path] = feed
+	}
+	return outlet, nil
+}
+
+func (step mockStep) validate(feedType string) error {
+	switch step.Action {
+	case mockPublish, mockEdit, mockDelete:
+		if step.Item.ID == "" {
+			return fmt.Errorf("%v needs an item with an id", step.Action)
+		}
+	case mockLoad:
+		if _, err := mockItemsFromFile(step.File, feedType); err != nil {
+			return err
+		}
+	case mockStatus:
+		if step.Status < 100 || step.Status > 599 {
+			return fmt.Errorf("status needs an HTTP status code, not %d", step.Status)
+		}
+	case mockSlow:
+		if step.Delay <= 0 {
+			return errors.New("slow needs a delay")
+		}
+	case mockMalformed:
+	default:
+		return fmt.Errorf("unknown action '%v'", step.Action)
+	}
+	return nil
+}
+
+func mockItemsFromFile(path string, feedType string) ([]mockItem, error) {
+	/*
+		Read the items out of a saved feed, such as one of the rss_tests snapshots, with the feed type's own parser
+	*/
+	feed, err := readFeedFile(path, feedTypes[feedType])
+	if err != nil {
+		return nil, err
+	}
+	var items []mockItem
+	for _, data := range feed.messageData() {
+		items = append(items, mockItem{ID: data.itemKey(), Title: data.Title, Description: data.Description, Link: data.Link, Categories: data.Categories})
+	}
+	return items, nil
+}
+
+func (outlet *mockOutlet) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if articleID, ok := strings.CutPrefix(r.URL.Path, "/articles/"); ok {
+		outlet.serveArticle(w, r, articleID)
+		return
+	}
+	feed, ok := outlet.feeds[r.URL.Path]
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+
+	feed.mu.Lock()
+	feed.requests++
+	feed.runSteps(time.Since(outlet.started))
+	var fault mockFault
+	if feed.fault != nil && feed.fault.remaining > 0 {
+		feed.fault.remaining--
+		fault = *feed.fault
+	}
+	body, err := feed.render("http://" + r.Host)
+	feed.mu.Unlock()
+	if err != nil {
+		slog.Error("rendering mock feed", "feed", feed.script.Path, "err", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	switch fault.action {
+	case mockStatus:
+		http.Error(w, http.StatusText(fault.status), fault.status)
+		return
+	case mockSlow:
+		select {
+		case <-time.After(fault.delay):
+		case <-r.Context().Done():
+			return
+		}
+	case mockMalformed:
+		// cut the document off partway through an element
+		body = body[:len(body)/2]
+	}
+	if atomFeedTypes[feed.script.Type] {
+		w.Header().Set("Content-Type", "application/atom+xml")
+	} else {
+		w.Header().Set("Content-Type", "application/rss+xml")
+	}
+	w.Write(body)
+}
+
+func (feed *mockFeed) runSteps(elapsed time.Duration) {
+	/*
+		Apply every step that's due, in the order the scenario lists them. Called with the feed locked.
+	*/
+	var remaining []mockStep
+	for _, step := range feed.pending {
+		due := time.Duration(step.At) <= elapsed
+		if step.Request > 0 {
+			due = feed.requests >= step.Request
+		}
+		if !due {
+			remaining = append(remaining, step)
+			continue
+		}
+		slog.Info("mock outlet step", "feed", feed.script.Path, "action", step.Action, "item", step.Item.ID, "request", feed.requests)
+		feed.apply(step)
+	}
+	feed.pending = remaining
+}
+
+func (feed *mockFeed) apply(step mockStep) {
+	switch step.Action {
+	case mockPublish:
+		item := step.Item
+		if item.Published.IsZero() {
+			item.Published = time.Now()
+		}
+		feed.removeItem(item.ID)
+		// newest first, as the outlets list them
+		feed.items = append([]mockItem{item}, feed.items...)
+	case mockEdit:
+		for idx := range feed.items {
+			if feed.items[idx].ID != step.Item.ID {
+				continue
+			}
+			if step.Item.Title != "" {
+				feed.items[idx].Title = step.Item.Title
+			}
+			if step.Item.Description != "" {
+				feed.items[idx].Description = step.Item.Description
+			}
+			if step.Item.Link != "" {
+				feed.items[idx].Link = step.Item.Link
+			}
+			if step.Item.Categories != nil {
+				feed.items[idx].Categories = step.Item.Categories
+			}
+		}
+	case mockDelete:
+		feed.removeItem(step.Item.ID)
+	case mockLoad:
+		// already read once when the scenario was checked
+		feed.items, _ = mockItemsFromFile(step.File, feed.script.Type)
+	case mockStatus, mockSlow, mockMalformed:
+		count := step.Count
+		if count < 1 {
+			count = 1
+		}
+		// starting with the request the step fired on
+		feed.fault = &mockFault{action: step.Action, status: step.Status, delay: time.Duration(step.Delay), remaining: count}
+	}
+}
+
+func (feed *mockFeed) removeItem(id string) {
+	for idx, item := range feed.items {
+		if item.ID == id {
+			feed.items = append(feed.items[:idx], feed.items[idx+1:]...)
+			return
+		}
+	}
+}
+
+func (feed *mockFeed) lookupItem(id string) (mockItem, bool) {
+	feed.mu.Lock()
+	defer feed.mu.Unlock()
+	for _, item := range feed.items {
+		if item.ID == id {
+			return item, true
+		}
+	}
+	return mockItem{}, false
+}
+
+func (item mockItem) link(base string) string {
+	if item.Link != "" {
+		return item.Link
+	}
+	return base + "/articles/" + url.PathEscape(item.ID)
+}
+
+type mockRss struct {
+	XMLName xml.Name `xml:"rss"`
+	Version string   `xml:"version,attr"`
+	Channel struct {
+		Title       string        `xml:"title"`
+		Link        string        `xml:"link"`
+		Description string        `xml:"description"`
+		Items       []mockRssItem `xml:"item"`
+	} `xml:"channel"`
+}
+
+type mockRssItem struct {
+	Title       string   `xml:"title"`
+	Link        string   `xml:"link"`
+	Description string   `xml:"description"`
+	PubDate     string   `xml:"pubDate,omitempty"`
+	GUID        string   `xml:"guid"`
+	Categories  []string `xml:"category"`
+}
+
+type mockAtom struct {
+	XMLName xml.Name        `xml:"http://www.w3.org/2005/Atom feed"`
+	Title   string          `xml:"title"`
+	Updated string          `xml:"updated"`
+	Entries []mockAtomEntry `xml:"entry"`
+}
+
+type mockAtomEntry struct {
+	ID        string `xml:"id"`
+	Title     string `xml:"title"`
+	Published string `xml:"published,omitempty"`
+	Summary   string `xml:"summary"`
+	Link      struct {
+		Rel  string `xml:"rel,attr"`
+		Type string `xml:"type,attr"`
+		Href string `xml:"href,attr"`
+	} `xml:"link"`
+}
+
+func (feed *mockFeed) render(base string) ([]byte, error) {
+	/*
+		Write the feed's current items out as RSS, or Atom for the feed types that read it. Called with the feed
+		locked.
+	*/
+	var document any
+	if atomFeedTypes[feed.script.Type] {
+		atom := mockAtom{Title: feed.script.Title, Updated: time.Now().UTC().Format(time.RFC3339)}
+		for _, item := range feed.items {
+			entry := mockAtomEntry{ID: item.ID, Title: item.Title, Summary: item.Description}
+			if !item.Published.IsZero() {
+				entry.Published = item.Published.UTC().Format(time.RFC3339)
+			}
+			entry.Link.Rel, entry.Link.Type, entry.Link.Href = "alternate", "text/html", item.link(base)
+			atom.Entries = append(atom.Entries, entry)
+		}
+		document = atom
+	} else {
+		rss := mockRss{Version: "2.0"}
+		rss.Channel.Title, rss.Channel.Link, rss.Channel.Description = feed.script.Title, base, feed.script.Title
+		for _, item := range feed.items {
+			rssItem := mockRssItem{Title: item.Title, Link: item.link(base), Description: item.Description, GUID: item.ID, Categories: item.Categories}
+			if !item.Published.IsZero() {
+				rssItem.PubDate = item.Published.UTC().Format(time.RFC1123Z)
+			}
+			rss.Channel.Items = append(rss.Channel.Items, rssItem)
+		}
+		document = rss
+	}
+
+	body, err := xml.MarshalIndent(document, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append([]byte(xml.Header), body...), nil
+}
+
+func (outlet *mockOutlet) serveArticle(w http.ResponseWriter, r *http.Request, articleID string) {
+	/*
+		A page for items without a link of their own, carrying their categories the way The Hacker News marks up
+		its tags, so the category scraper has something to find
+	*/
+	id, err := url.PathUnescape(articleID)
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	for _, feed := range outlet.feeds {
+		if item, ok := feed.lookupItem(id); ok {
+			w.Header().Set("Content-Type", "text/html; charset=utf-8")
+			fmt.Fprintf(w, "<html>\n<head><title>%s</title></head>\n<body>\n<div class=\"postmeta\"><span class='p-tags'>%s</span></div>\n<p>%s</p>\n</body>\n</html>\n",
+				xmlEscape(item.Title), xmlEscape(strings.Join(item.Categories, " / ")), xmlEscape(item.Description))
+			return
+		}
+	}
+	http.NotFound(w, r)
+}
+
+func xmlEscape(text string) string {
+	var escaped strings.Builder
+	xml.EscapeText(&escaped, []byte(text))
+	return escaped.String()
+}
+
+func mockOutletCommand(args []string) int {
+	flags := flag.NewFlagSet("mock-outlet", flag.ContinueOnError)
+	listen := flags.String("listen", "127.0.0.1:8081", "the address to serve the feeds on")
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+	if flags.NArg() != 1 {
+		fmt.Fprintln(os.Stderr, "usage: mock-outlet [-listen addr] <scenario.json>")
+		return 2
+	}
+
+	scenario, err := loadMockScenario(flags.Arg(0))
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	outlet, err := newMockOutlet(scenario)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	server := &http.Server{Addr: *listen, Handler: outlet, ReadHeaderTimeout: 10 * time.Second}
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	go func() {
+		<-ctx.Done()
+		server.Close()
+	}()
+
+	for _, script := range scenario.Feeds {
+		fmt.Printf("serving %s feed at http://%s%s\n", script.Type, *listen, script.Path)
+	}
+	if err = server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	return 0
+}
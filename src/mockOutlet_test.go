@@ -0,0 +1,141 @@
+package main
+
+import (
+	"context"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func startMockOutlet(t *testing.T, scenario mockScenario) *httptest.Server {
+	t.Helper()
+	outlet, err := newMockOutlet(scenario)
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := httptest.NewServer(outlet)
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestMockOutletTimeline(t *testing.T) {
+	server := startMockOutlet(t, mockScenario{Feeds: []mockFeedScript{{
+		Path:  "/zdi",
+		Type:  "zdi",
+		Title: "Mock ZDI",
+		Seed:  "../rss_tests/zdi/oldfeed.xml",
+		Steps: []mockStep{
+			{Request: 2, Action: mockPublish, Item: mockItem{ID: "new-1", Title: "New advisory", Description: "Details"}},
+			{Request: 3, Action: mockEdit, Item: mockItem{ID: "new-1", Title: "New advisory (updated)"}},
+			{Request: 4, Action: mockStatus, Status: 429},
+			{Request: 5, Action: mockMalformed},
+			{Request: 6, Action: mockDelete, Item: mockItem{ID: "new-1"}},
+			{Request: 6, Action: mockSlow, Delay: scenarioDuration(50 * time.Millisecond)},
+		},
+	}}})
+	feedUrl := server.URL + "/zdi"
+	fetch := func() (RSSFeed, error) {
+		return fetchFeed(context.Background(), feedUrl, feedTypes["zdi"])
+	}
+
+	baseline, err := fetch()
+	if err != nil {
+		t.Fatal(err)
+	}
+	seeded := len(baseline.messageData())
+
+	published, err := fetch()
+	if err != nil {
+		t.Fatal(err)
+	}
+	items, err := parseNewRssContent(context.Background(), baseline, published)
+	if err != nil || len(items) != 1 || items[0].Title != "New advisory" || items[0].Updated {
+		t.Fatalf("expected the published item to be new, got %+v, %v", items, err)
+	}
+	if !strings.HasPrefix(items[0].Link, server.URL+"/articles/") {
+		t.Errorf("item without a link should point at the mock's article page, got %v", items[0].Link)
+	}
+
+	edited, err := fetch()
+	if err != nil {
+		t.Fatal(err)
+	}
+	items, err = parseNewRssContent(context.Background(), published, edited)
+	if err != nil || len(items) != 1 || items[0].Title != "New advisory (updated)" || !items[0].Updated {
+		t.Fatalf("expected the edited item as an update, got %+v, %v", items, err)
+	}
+
+	if _, err = fetch(); err == nil || !strings.Contains(err.Error(), "429") {
+		t.Errorf("expected to be rate limited, got %v", err)
+	}
+	if _, err = fetch(); err == nil || !strings.Contains(err.Error(), "unmarshaling") {
+		t.Errorf("expected malformed XML, got %v", err)
+	}
+
+	start := time.Now()
+	deleted, err := fetch()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if time.Since(start) < 50*time.Millisecond {
+		t.Error("slow response wasn't delayed")
+	}
+	if len(deleted.messageData()) != seeded {
+		t.Errorf("expected the deleted item to be gone, got %d items", len(deleted.messageData()))
+	}
+}
+
+func TestMockOutletArticlePages(t *testing.T) {
+	server := startMockOutlet(t, mockScenario{Feeds: []mockFeedScript{{
+		Path: "/hn",
+		Type: "hackernews",
+		Items: []mockItem{
+			{ID: "boring", Title: "Boring"},
+		},
+		Steps: []mockStep{
+			{Request: 2, Action: mockPublish, Item: mockItem{ID: "zero-day", Title: "Zero-day", Categories: []string{"Vulnerability", "Zero-Day"}}},
+			{Request: 2, Action: mockPublish, Item: mockItem{ID: "opinion", Title: "Opinion", Categories: []string{"Opinion"}}},
+		},
+	}}})
+
+	baseline, err := fetchFeed(context.Background(), server.URL+"/hn", feedTypes["hackernews"])
+	if err != nil {
+		t.Fatal(err)
+	}
+	current, err := fetchFeed(context.Background(), server.URL+"/hn", feedTypes["hackernews"])
+	if err != nil {
+		t.Fatal(err)
+	}
+	items, err := parseNewRssContent(context.Background(), baseline, current)
+	if err != nil || len(items) != 1 || items[0].Title != "Zero-day" || len(items[0].Categories) != 2 {
+		t.Errorf("expected only the interesting article, with its scraped categories, got %+v, %v", items, err)
+	}
+}
+
+func TestMockScenarioValidation(t *testing.T) {
+	scenarios := map[string]mockScenario{
+		"no feeds":       {},
+		"unknown type":   {Feeds: []mockFeedScript{{Path: "/a", Type: "nope"}}},
+		"relative path":  {Feeds: []mockFeedScript{{Path: "a", Type: "zdi"}}},
+		"duplicate path": {Feeds: []mockFeedScript{{Path: "/a", Type: "zdi"}, {Path: "/a", Type: "zdi"}}},
+		"unknown action": {Feeds: []mockFeedScript{{Path: "/a", Type: "zdi", Steps: []mockStep{{Action: "explode"}}}}},
+		"no item id":     {Feeds: []mockFeedScript{{Path: "/a", Type: "zdi", Steps: []mockStep{{Action: mockPublish}}}}},
+		"bad status":     {Feeds: []mockFeedScript{{Path: "/a", Type: "zdi", Steps: []mockStep{{Action: mockStatus}}}}},
+	}
+	for name, scenario := range scenarios {
+		if _, err := newMockOutlet(scenario); err == nil {
+			t.Errorf("%s scenario was accepted", name)
+		}
+	}
+}
+
+func TestExampleScenarioLoads(t *testing.T) {
+	scenario, err := loadMockScenario("../scenarios/example.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = newMockOutlet(scenario); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,98 @@
+/*
+Fetches a web page and pulls out the title, description and preview image, from OpenGraph tags where the page has them
+and the plain <title> and meta description otherwise. Used to fill in /send submissions the admin didn't describe.
+*/
+package main
+
+import (
+	"fmt"
+	"html"
+	"io"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+)
+
+const (
+	pageFetchTimeout = 10 * time.Second
+	// everything we want is in the <head>, so there's no need to read more than the start of a page
+	pageSizeCap = 1 << 20
+)
+
+var (
+	pageClient = &http.Client{Timeout: pageFetchTimeout}
+
+	titlePattern     = regexp.MustCompile(`(?is)<title[^>]*>(.*?)</title>`)
+	metaTagPattern   = regexp.MustCompile(`(?is)<meta\s[^>]*>`)
+	attributePattern = regexp.MustCompile(`(?is)([a-z][a-z0-9:_-]*)\s*=\s*(?:"([^"]*)"|'([^']*)')`)
+)
+
+type pageMetadata struct {
+	Title       string
+	Description string
+	Image       string
+}
+
+func fetchPageMetadata(pageUrl string) (meta pageMetadata, err error) {
+	/*
+		Download at most pageSizeCap bytes of the page, giving up after pageFetchTimeout
+	*/
+	resp, err := pageClient.Get(pageUrl)
+	if err != nil {
+		return meta, fmt.Errorf("err: fetching page - %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return meta, fmt.Errorf("err: page status code was '%d' not 200", resp.StatusCode)
+	}
+	if contentType := resp.Header.Get("Content-Type"); contentType != "" && !strings.Contains(contentType, "html") {
+		return meta, fmt.Errorf("err: page is '%v', not HTML", contentType)
+	}
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, pageSizeCap))
+	if err != nil {
+		return meta, fmt.Errorf("err: reading page - %v", err)
+	}
+	return parsePageMetadata(string(body)), nil
+}
+
+func parsePageMetadata(page string) (meta pageMetadata) {
+	/*
+		OpenGraph tags are written for exactly this kind of preview, so they win over the generic tags
+	*/
+	tags := make(map[string]string)
+	for _, tag := range metaTagPattern.FindAllString(page, -1) {
+		attrs := make(map[string]string)
+		for _, attr := range attributePattern.FindAllStringSubmatch(tag, -1) {
+			attrs[strings.ToLower(attr[1])] = attr[2] + attr[3]
+		}
+
+		key := attrs["property"]
+		if key == "" {
+			key = attrs["name"]
+		}
+		key = strings.ToLower(key)
+		if _, seen := tags[key]; key != "" && !seen {
+			tags[key] = strings.TrimSpace(html.UnescapeString(attrs["content"]))
+		}
+	}
+
+	meta.Title = tags["og:title"]
+	if meta.Title == "" {
+		if match := titlePattern.FindStringSubmatch(page); match != nil {
+			meta.Title = strings.Join(strings.Fields(html.UnescapeString(match[1])), " ")
+		}
+	}
+	meta.Description = tags["og:description"]
+	if meta.Description == "" {
+		meta.Description = tags["description"]
+	}
+	meta.Image = tags["og:image"]
+	if !strings.HasPrefix(meta.Image, "http://") && !strings.HasPrefix(meta.Image, "https://") {
+		// relative or missing images can't be shown in an embed
+		meta.Image = ""
+	}
+	return
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParsePageMetadata(t *testing.T) {
+	cases := []struct {
+		name string
+		page string
+		want pageMetadata
+	}{
+		{
+			"opengraph wins",
+			`<html><head><title>Plain title</title><meta name="description" content="Plain description">
+			<meta property="og:title" content="OG title"><meta property='og:description' content='OG description'>
+			<meta property="og:image" content="https://example.com/image.png"></head></html>`,
+			pageMetadata{Title: "OG title", Description: "OG description", Image: "https://example.com/image.png"},
+		},
+		{
+			"plain tags without opengraph",
+			"<html><head><TITLE>\n  Plain\n  title </TITLE><META NAME=\"Description\" CONTENT=\"Plain description\"></head></html>",
+			pageMetadata{Title: "Plain title", Description: "Plain description"},
+		},
+		{
+			"entities",
+			`<title>Tom &amp; Jerry&#39;s &quot;bug&quot;</title><meta name="description" content="1 &lt; 2 &#x26; more">`,
+			pageMetadata{Title: `Tom & Jerry's "bug"`, Description: "1 < 2 & more"},
+		},
+		{
+			"first of a repeated tag",
+			`<meta property="og:title" content="First"><meta property="og:title" content="Second">`,
+			pageMetadata{Title: "First"},
+		},
+		{
+			"relative image dropped",
+			`<title>Title</title><meta property="og:image" content="/image.png">`,
+			pageMetadata{Title: "Title"},
+		},
+		{
+			"nothing to find",
+			`<html><body><p>No head at all</p></body></html>`,
+			pageMetadata{},
+		},
+		{
+			"unclosed title",
+			`<title>Never closed<meta name="description">`,
+			pageMetadata{},
+		},
+	}
+	for _, c := range cases {
+		if got := parsePageMetadata(c.page); got != c.want {
+			t.Errorf("%s: got %+v, want %+v", c.name, got, c.want)
+		}
+	}
+}
+
+func TestFetchPageMetadataReadsOnlyTheCap(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		w.Write([]byte("<title>Early</title>"))
+		w.Write([]byte(strings.Repeat(" ", pageSizeCap)))
+		w.Write([]byte(`<meta property="og:title" content="Past the cap">`))
+	}))
+	defer server.Close()
+
+	meta, err := fetchPageMetadata(server.URL)
+	if err != nil || meta.Title != "Early" {
+		t.Errorf("expected only the first %d bytes to be read, got %+v, %v", pageSizeCap, meta, err)
+	}
+}
+
+func TestFetchPageMetadataRefusesNonHTML(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/pdf":
+			w.Header().Set("Content-Type", "application/pdf")
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	for _, path := range []string{"/pdf", "/missing"} {
+		if _, err := fetchPageMetadata(server.URL + path); err == nil {
+			t.Errorf("%s: expected an error", path)
+		}
+	}
+}
+
+func TestFetchPageMetadataTimesOut(t *testing.T) {
+	defer func(client *http.Client) { pageClient = client }(pageClient)
+	pageClient = &http.Client{Timeout: 100 * time.Millisecond}
+
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// send the headers, then stall part way through the page
+		w.Header().Set("Content-Type", "text/html")
+		w.Write([]byte("<title>Stalled"))
+		w.(http.Flusher).Flush()
+		select {
+		case <-release:
+		case <-r.Context().Done():
+		}
+	}))
+	defer server.Close()
+	defer close(release)
+
+	start := time.Now()
+	if _, err := fetchPageMetadata(server.URL); err == nil {
+		t.Error("expected a stalled page to time out")
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("fetch took %v, the timeout wasn't applied", elapsed)
+	}
+}
@@ -0,0 +1,290 @@
+/*
+Permission model for the bot's commands. Each command needs a capability, and capabilities are granted per guild to
+roles, users or Discord permissions with /permissions. Until a capability has been granted to anyone, it falls back to
+the Committee and Prior Committee roles, and server administrators can always do everything.
+*/
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"strings"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+type capability string
+
+const (
+	capSubmit      capability = "submit"
+	capManageFeeds capability = "manage_feeds"
+	capModerate    capability = "moderate"
+	capViewStatus  capability = "view_status"
+)
+
+var capabilities = []capability{capSubmit, capManageFeeds, capModerate, capViewStatus}
+
+// the Discord permissions that can be granted a capability, by the name used in /permissions
+var grantablePermissions = map[string]int64{
+	"administrator":   discordgo.PermissionAdministrator,
+	"manage_server":   discordgo.PermissionManageServer,
+	"manage_channels": discordgo.PermissionManageChannels,
+	"manage_messages": discordgo.PermissionManageMessages,
+	"manage_roles":    discordgo.PermissionManageRoles,
+}
+
+const (
+	grantRole       = "role"
+	grantUser       = "user"
+	grantPermission = "permission"
+)
+
+// permissionGrant gives a capability to a role ID, user ID or named Discord permission
+type permissionGrant struct {
+	Capability capability `json:"capability"`
+	Kind       string     `json:"kind"`
+	Value      string     `json:"value"`
+}
+
+type guildPermissions struct {
+	Grants []permissionGrant `json:"grants"`
+}
+
+func (grant permissionGrant) describe() string {
+	switch grant.Kind {
+	case grantRole:
+		return fmt.Sprintf("<@&%s>", grant.Value)
+	case grantUser:
+		return fmt.Sprintf("<@%s>", grant.Value)
+	}
+	return fmt.Sprintf("members with %s", grant.Value)
+}
+
+func (st *stateStore) guildGrants(guildId string) []permissionGrant {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	perms, ok := st.data.Permissions[guildId]
+	if !ok {
+		return nil
+	}
+	return append([]permissionGrant(nil), perms.Grants...)
+}
+
+func (st *stateStore) addGrant(guildId string, grant permissionGrant) error {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	perms, ok := st.data.Permissions[guildId]
+	if !ok {
+		perms = &guildPermissions{}
+		st.data.Permissions[guildId] = perms
+	}
+	for _, existing := range perms.Grants {
+		if existing == grant {
+			return nil
+		}
+	}
+	perms.Grants = append(perms.Grants, grant)
+	return st.save()
+}
+
+func (st *stateStore) removeGrant(guildId string, grant permissionGrant) (bool, error) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	perms, ok := st.data.Permissions[guildId]
+	if !ok {
+		return false, nil
+	}
+	for idx, existing := range perms.Grants {
+		if existing == grant {
+			perms.Grants = append(perms.Grants[:idx], perms.Grants[idx+1:]...)
+			return true, st.save()
+		}
+	}
+	return false, nil
+}
+
+func hasCapability(guildId string, member *discordgo.Member, permissions int64, cap capability) bool {
+	/*
+		Check whether a member may use a capability in a guild. permissions are the member's Discord permissions in
+		the channel they're acting in.
+	*/
+	if member == nil {
+		return false
+	}
+	if permissions&discordgo.PermissionAdministrator != 0 {
+		return true
+	}
+
+	granted := false
+	for _, grant := range store.guildGrants(guildId) {
+		if grant.Capability != cap {
+			continue
+		}
+		granted = true
+
+		switch grant.Kind {
+		case grantRole:
+			for _, role := range member.Roles {
+				if role == grant.Value {
+					return true
+				}
+			}
+		case grantUser:
+			if member.User != nil && member.User.ID == grant.Value {
+				return true
+			}
+		case grantPermission:
+			if bit, ok := grantablePermissions[grant.Value]; ok && permissions&bit == bit {
+				return true
+			}
+		}
+	}
+
+	// nobody has been given the capability yet, so it stays with the committee
+	return !granted && hasAdminRole(member.Roles)
+}
+
+func capabilityChoices() []*discordgo.ApplicationCommandOptionChoice {
+	choices := make([]*discordgo.ApplicationCommandOptionChoice, 0, len(capabilities))
+	for _, cap := range capabilities {
+		choices = append(choices, &discordgo.ApplicationCommandOptionChoice{Name: string(cap), Value: string(cap)})
+	}
+	return choices
+}
+
+func permissionsCommand() *discordgo.ApplicationCommand {
+	permissionChoices := make([]*discordgo.ApplicationCommandOptionChoice, 0, len(grantablePermissions))
+	for _, name := range []string{"administrator", "manage_server", "manage_channels", "manage_messages", "manage_roles"} {
+		permissionChoices = append(permissionChoices, &discordgo.ApplicationCommandOptionChoice{Name: name, Value: name})
+	}
+	grantOptions := func() []*discordgo.ApplicationCommandOption {
+		return []*discordgo.ApplicationCommandOption{
+			{
+				Type:        discordgo.ApplicationCommandOptionString,
+				Name:        "capability",
+				Description: "What the grant allows",
+				Required:    true,
+				Choices:     capabilityChoices(),
+			},
+			{
+				Type:        discordgo.ApplicationCommandOptionRole,
+				Name:        "role",
+				Description: "Grant to everyone with this role",
+				Required:    false,
+			},
+			{
+				Type:        discordgo.ApplicationCommandOptionUser,
+				Name:        "user",
+				Description: "Grant to this member",
+				Required:    false,
+			},
+			{
+				Type:        discordgo.ApplicationCommandOptionString,
+				Name:        "permission",
+				Description: "Grant to everyone with this Discord permission",
+				Required:    false,
+				Choices:     permissionChoices,
+			},
+		}
+	}
+	manageServer := int64(discordgo.PermissionManageServer)
+
+	return &discordgo.ApplicationCommand{
+		Name:                     "permissions",
+		Description:              "Configure who can use the bot's commands",
+		DefaultMemberPermissions: &manageServer,
+
+		Options: []*discordgo.ApplicationCommandOption{
+			{
+				Type:        discordgo.ApplicationCommandOptionSubCommand,
+				Name:        "grant",
+				Description: "Give a role, member or Discord permission a capability",
+				Options:     grantOptions(),
+			},
+			{
+				Type:        discordgo.ApplicationCommandOptionSubCommand,
+				Name:        "revoke",
+				Description: "Take a capability away from a role, member or Discord permission",
+				Options:     grantOptions(),
+			},
+			{
+				Type:        discordgo.ApplicationCommandOptionSubCommand,
+				Name:        "list",
+				Description: "Show who has each capability",
+			},
+		},
+	}
+}
+
+func permissionsCommandHandler(s discordClient, i *discordgo.InteractionCreate) {
+	/*
+		Only members who can manage the server may change the bot's permissions, regardless of any grants. That way
+		the bot can always be configured, even before any roles are set up.
+	*/
+	if i.Member == nil || i.Member.Permissions&(discordgo.PermissionManageServer|discordgo.PermissionAdministrator) == 0 {
+		interactionRespondEphemeral(s, i, "You need the Manage Server permission to configure the bot's permissions")
+		return
+	}
+
+	subcommand := i.ApplicationCommandData().Options[0]
+	if subcommand.Name == "list" {
+		interactionRespond(s, i, describeGuildPermissions(i.GuildID))
+		return
+	}
+
+	optionMap := optionsMap(subcommand.Options)
+	grant := permissionGrant{Capability: capability(optionMap["capability"].StringValue())}
+	if opt, ok := optionMap["role"]; ok {
+		grant.Kind, grant.Value = grantRole, opt.RoleValue(nil, "").ID
+	} else if opt, ok := optionMap["user"]; ok {
+		grant.Kind, grant.Value = grantUser, opt.UserValue(nil).ID
+	} else if opt, ok := optionMap["permission"]; ok {
+		grant.Kind, grant.Value = grantPermission, opt.StringValue()
+	} else {
+		interactionRespondEphemeral(s, i, "Please choose a role, user or permission")
+		return
+	}
+
+	if subcommand.Name == "grant" {
+		if err := store.addGrant(i.GuildID, grant); err != nil {
+			slog.Error("saving state", "err", err)
+		}
+		auditInteraction(i, "granted")
+		interactionRespond(s, i, fmt.Sprintf("Granted %s to %s", grant.Capability, grant.describe()))
+		return
+	}
+
+	removed, err := store.removeGrant(i.GuildID, grant)
+	if err != nil {
+		slog.Error("saving state", "err", err)
+	}
+	if !removed {
+		interactionRespond(s, i, fmt.Sprintf("%s didn't have %s", grant.describe(), grant.Capability))
+		return
+	}
+	auditInteraction(i, "revoked")
+	interactionRespond(s, i, fmt.Sprintf("Revoked %s from %s", grant.Capability, grant.describe()))
+}
+
+func describeGuildPermissions(guildId string) string {
+	grants := store.guildGrants(guildId)
+
+	var lines []string
+	for _, cap := range capabilities {
+		var holders []string
+		for _, grant := range grants {
+			if grant.Capability == cap {
+				holders = append(holders, grant.describe())
+			}
+		}
+		if len(holders) == 0 {
+			holders = append(holders, "the committee roles (default)")
+		}
+		lines = append(lines, fmt.Sprintf("**%s**: %s", cap, strings.Join(holders, ", ")))
+	}
+	lines = append(lines, "Server administrators can always use every command.")
+	return strings.Join(lines, "\n")
+}
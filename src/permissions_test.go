@@ -0,0 +1,119 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+func roleOption(roleID string) *discordgo.ApplicationCommandInteractionDataOption {
+	return &discordgo.ApplicationCommandInteractionDataOption{Name: "role", Type: discordgo.ApplicationCommandOptionRole, Value: roleID}
+}
+
+func userOption(userID string) *discordgo.ApplicationCommandInteractionDataOption {
+	return &discordgo.ApplicationCommandInteractionDataOption{Name: "user", Type: discordgo.ApplicationCommandOptionUser, Value: userID}
+}
+
+// checkCapabilities compares what each member may do against want, keyed by member name
+func checkCapabilities(t *testing.T, step string, members map[string]*discordgo.Member, want map[string][]capability) {
+	t.Helper()
+	for name, member := range members {
+		allowed := map[capability]bool{}
+		for _, cap := range want[name] {
+			allowed[cap] = true
+		}
+		for _, cap := range capabilities {
+			if got := hasCapability(testGuild, member, member.Permissions, cap); got != allowed[cap] {
+				t.Errorf("%s: %s having %s = %t, want %t", step, name, cap, got, allowed[cap])
+			}
+		}
+	}
+}
+
+func TestPermissionGrantsAndFallback(t *testing.T) {
+	fake := setupFakeDiscord(t)
+	owner := testMember("owner", discordgo.PermissionManageServer)
+	members := map[string]*discordgo.Member{
+		"admin":     testMember("admin", discordgo.PermissionAdministrator),
+		"committee": testMember("committee-member", 0, "committee"),
+		"prior":     testMember("prior-member", 0, "prior-committee"),
+		"editor":    testMember("editor", 0, "editors"),
+		"alice":     testMember("alice", 0),
+		"mod":       testMember("mod", discordgo.PermissionManageMessages),
+		"member":    testMember("member", 0),
+	}
+	everything := capabilities
+
+	// with no grants the committee roles have every capability
+	checkCapabilities(t, "no grants", members, map[string][]capability{
+		"admin": everything, "committee": everything, "prior": everything,
+	})
+
+	interactionHandler(fake, commandInteraction("permissions", owner, subcommandOption("grant", stringOption("capability", "manage_feeds"), roleOption("editors"))))
+	interactionHandler(fake, commandInteraction("permissions", owner, subcommandOption("grant", stringOption("capability", "moderate"), userOption("alice"))))
+	interactionHandler(fake, commandInteraction("permissions", owner, subcommandOption("grant", stringOption("capability", "view_status"), stringOption("permission", "manage_messages"))))
+	if response := fake.lastResponse(t); response != "Granted view_status to members with manage_messages" {
+		t.Errorf("unexpected response %q", response)
+	}
+	// granting again doesn't add a second grant
+	interactionHandler(fake, commandInteraction("permissions", owner, subcommandOption("grant", stringOption("capability", "moderate"), userOption("alice"))))
+	if grants := store.guildGrants(testGuild); len(grants) != 3 {
+		t.Errorf("expected 3 grants, got %+v", grants)
+	}
+
+	// a granted capability belongs to its grantees alone, the committee keeps the rest
+	checkCapabilities(t, "after granting", members, map[string][]capability{
+		"admin":     everything,
+		"committee": {capSubmit},
+		"prior":     {capSubmit},
+		"editor":    {capManageFeeds},
+		"alice":     {capModerate},
+		"mod":       {capViewStatus},
+	})
+
+	interactionHandler(fake, commandInteraction("permissions", owner, subcommandOption("list")))
+	list := fake.lastResponse(t)
+	for _, want := range []string{"**submit**: the committee roles (default)", "**manage_feeds**: <@&editors>", "**moderate**: <@alice>", "**view_status**: members with manage_messages"} {
+		if !strings.Contains(list, want) {
+			t.Errorf("expected %q in the list, got %q", want, list)
+		}
+	}
+
+	// taking the only grant away hands the capability back to the committee
+	interactionHandler(fake, commandInteraction("permissions", owner, subcommandOption("revoke", stringOption("capability", "manage_feeds"), roleOption("editors"))))
+	if response := fake.lastResponse(t); response != "Revoked manage_feeds from <@&editors>" {
+		t.Errorf("unexpected response %q", response)
+	}
+	interactionHandler(fake, commandInteraction("permissions", owner, subcommandOption("revoke", stringOption("capability", "manage_feeds"), roleOption("editors"))))
+	if response := fake.lastResponse(t); response != "<@&editors> didn't have manage_feeds" {
+		t.Errorf("unexpected response %q", response)
+	}
+	checkCapabilities(t, "after revoking", members, map[string][]capability{
+		"admin":     everything,
+		"committee": {capSubmit, capManageFeeds},
+		"prior":     {capSubmit, capManageFeeds},
+		"alice":     {capModerate},
+		"mod":       {capViewStatus},
+	})
+}
+
+func TestPermissionsNeedManageServer(t *testing.T) {
+	fake := setupFakeDiscord(t)
+
+	// the committee can use the bot, but not change who else can
+	interactionHandler(fake, commandInteraction("permissions", testMember("committee-member", 0, "committee"),
+		subcommandOption("grant", stringOption("capability", "moderate"), userOption("committee-member"))))
+	if response := fake.lastResponse(t); !strings.HasPrefix(response, "You need the Manage Server permission") {
+		t.Errorf("unexpected response %q", response)
+	}
+	if grants := store.guildGrants(testGuild); len(grants) != 0 {
+		t.Errorf("grant saved without Manage Server: %+v", grants)
+	}
+
+	interactionHandler(fake, commandInteraction("permissions", testMember("owner", discordgo.PermissionManageServer),
+		subcommandOption("grant", stringOption("capability", "moderate"))))
+	if response := fake.lastResponse(t); response != "Please choose a role, user or permission" {
+		t.Errorf("unexpected response %q", response)
+	}
+}
@@ -4,9 +4,10 @@ Code for handling the RSS feed provided by PortSwigger
 package main
 
 import (
+	"context"
 	"encoding/xml"
 	"errors"
-	"log"
+	"log/slog"
 )
 
 type PortSwiggerRSSFeed struct {
@@ -33,45 +34,48 @@ type PortSwiggerItem struct {
 	Comments    string `xml:"comments"`
 }
 
-func (pz *PortSwiggerRSSFeed) ParseNewRssContent(oldData RSSFeed, newData RSSFeed) ([]discordMessageData, error) {
+func (item PortSwiggerItem) messageData() discordMessageData {
+	data := discordMessageData{
+		ID:          item.GUID,
+		Title:       item.Title,
+		Description: item.Description,
+		Link:        item.Link,
+		Published:   parseFeedDate(item.PubDate),
+	}
+	if item.Category != "" {
+		data.Categories = []string{item.Category}
+	}
+	return data
+}
+
+func (pz *PortSwiggerRSSFeed) messageData() []discordMessageData {
+	items := make([]discordMessageData, 0, len(pz.Channel.Items))
+	for _, item := range pz.Channel.Items {
+		items = append(items, item.messageData())
+	}
+	return items
+}
+
+func (pz *PortSwiggerRSSFeed) ParseNewRssContent(ctx context.Context, oldData RSSFeed, newData RSSFeed) ([]discordMessageData, error) {
 	var (
-		oldHNData  *PortSwiggerRSSFeed
-		newHNData  *PortSwiggerRSSFeed
-		newContent []discordMessageData
+		oldPSData *PortSwiggerRSSFeed
+		newPSData *PortSwiggerRSSFeed
 
 		ok bool
 	)
 
-	if oldHNData, ok = oldData.(*PortSwiggerRSSFeed); !ok {
-		return nil, errors.New("error: oldData is not of type HackerNewsRssFeed")
+	if oldPSData, ok = oldData.(*PortSwiggerRSSFeed); !ok {
+		return nil, errors.New("error: oldData is not of type PortSwiggerRSSFeed")
 	}
-	if newHNData, ok = newData.(*PortSwiggerRSSFeed); !ok {
-		return nil, errors.New("error: newData is not of type HackerNewsRssFeed")
+	if newPSData, ok = newData.(*PortSwiggerRSSFeed); !ok {
+		return nil, errors.New("error: newData is not of type PortSwiggerRSSFeed")
 	}
 
-	for _, newFeedItem := range newHNData.Channel.Items {
-		itemExists := false
-		for _, oldFeedItem := range oldHNData.Channel.Items {
-			if newFeedItem.Title == oldFeedItem.Title {
-				// log.Printf("Article titled '%v' already exists in old data. Stopping iteration.\n", newFeedItem.Title)
-				itemExists = true
-				break
-			}
-		}
-		if !itemExists {
-			log.Printf("Article '%v' is new", newFeedItem.Title)
-			log.Println(newFeedItem)
-
-			messageContent := discordMessageData{
-				Title:       newFeedItem.Title,
-				Description: newFeedItem.Description,
-				Link:        newFeedItem.Link,
-			}
-			newContent = append(newContent, messageContent)
-		}
-	}
-	if len(newContent) > 20 {
-		return nil, errors.New("err: more than 20 new items. Logic bug likely. Exiting")
+	newContent, updatedContent := diffRssItems(oldPSData.messageData(), newPSData.messageData())
+	for _, item := range newContent {
+		slog.Debug("new article", "title", item.Title, "item", item.itemKey())
 	}
+
+	newContent = append(newContent, updatedContent...)
 	return newContent, nil
 }
@@ -4,23 +4,25 @@ Code for handling the RSS feed provided by Google Project Zero
 package main
 
 import (
+	"context"
 	"encoding/xml"
 	"errors"
-	"log"
-	"regexp"
+	"log/slog"
 )
 
 type ProjectZeroRssFeed struct {
 	XMLName xml.Name             `xml:"http://www.w3.org/2005/Atom feed"`
+	Base    string               `xml:"http://www.w3.org/XML/1998/namespace base,attr"`
 	Title   string               `xml:"title"`
-	Link    string               `xml:"link"`
+	Links   []atomLink           `xml:"link"`
 	Updated string               `xml:"updated"`
 	Items   []ProjectZeroRssItem `xml:"entry"`
 }
 
 type ProjectZeroRssItem struct {
+	Base      string                `xml:"http://www.w3.org/XML/1998/namespace base,attr"`
 	Title     string                `xml:"title"`
-	Link      []ProjectZeroRssLink  `xml:"link"`
+	Link      []atomLink            `xml:"link"`
 	Published string                `xml:"published"`
 	Updated   string                `xml:"updated"`
 	Summary   ProjectZeroRssSummary `xml:"summary"`
@@ -28,11 +30,6 @@ type ProjectZeroRssItem struct {
 	Id        string                `xml:"id"`
 }
 
-type ProjectZeroRssLink struct {
-	Rel  string `xml:"rel,attr"`
-	Href string `xml:"href,attr"`
-}
-
 type ProjectZeroRssSummary struct {
 	Type    string `xml:"type,attr"`
 	Summary string `xml:",chardata"`
@@ -43,58 +40,56 @@ type ProjectZeroRssContent struct {
 	Content string `xml:",chardata"`
 }
 
-func (pz *ProjectZeroRssFeed) ParseNewRssContent(oldData RSSFeed, newData RSSFeed) ([]discordMessageData, error) {
-	var (
-		oldHNData  *ProjectZeroRssFeed
-		newHNData  *ProjectZeroRssFeed
-		newContent []discordMessageData
+func (item ProjectZeroRssItem) messageData(base string) discordMessageData {
+	/*
+		base is the feed's base URL, for entries with relative links. An entry without a usable link is left with
+		no Link, and reported rather than posted
+	*/
+	newsLink := resolveAtomLink(resolveReference(base, item.Base), item.Link)
 
-		ok bool
-	)
-	if oldHNData, ok = oldData.(*ProjectZeroRssFeed); !ok {
-		return nil, errors.New("error: oldData is not of type HackerNewsRssFeed")
+	published := parseFeedDate(item.Published)
+	if published.IsZero() {
+		published = parseFeedDate(item.Updated)
 	}
-	if newHNData, ok = newData.(*ProjectZeroRssFeed); !ok {
-		return nil, errors.New("error: newData is not of type HackerNewsRssFeed")
+	return discordMessageData{
+		ID:          item.Id,
+		Title:       item.Title,
+		Description: item.Summary.Summary,
+		Link:        newsLink,
+		Published:   published,
+		Modified:    parseFeedDate(item.Updated),
+		ContentHash: hashContent(item.Content.Content),
 	}
+}
 
-	for _, newFeedItem := range newHNData.Items {
-		itemExists := false
-		for _, oldFeedItem := range oldHNData.Items {
-			if newFeedItem.Title == oldFeedItem.Title {
-				// log.Printf("Article titled '%v' already exists in old data. Stopping iteration.\n", newFeedItem.Title)
-				itemExists = true
-				break
-			}
-		}
-		if !itemExists {
-			log.Printf("Article '%v' is new", newFeedItem.Title)
-			log.Println(newFeedItem)
+func (pz *ProjectZeroRssFeed) messageData() []discordMessageData {
+	items := make([]discordMessageData, 0, len(pz.Items))
+	base := atomBase(pz.Base, pz.Links)
+	for _, item := range pz.Items {
+		items = append(items, item.messageData(base))
+	}
+	return items
+}
 
-			pattern := regexp.MustCompile("https://googleprojectzero\\.blogspot\\.com/\\d\\d\\d\\d")
-			var newsLink string
-			for _, str := range newFeedItem.Link {
-				matches := pattern.FindString(str.Href)
-				if matches != "" {
-					newsLink = str.Href
-					break
-				}
-			}
-			if newsLink == "" {
-				newsLink = "Unable to resolve link. Scream at @sharkmoos to fix."
-			}
+func (pz *ProjectZeroRssFeed) ParseNewRssContent(ctx context.Context, oldData RSSFeed, newData RSSFeed) ([]discordMessageData, error) {
+	var (
+		oldPZData *ProjectZeroRssFeed
+		newPZData *ProjectZeroRssFeed
 
-			messageContent := discordMessageData{
-				Title:       newFeedItem.Title,
-				Description: newFeedItem.Summary.Summary,
-				Link:        newsLink,
-			}
-			newContent = append(newContent, messageContent)
-		}
+		ok bool
+	)
+	if oldPZData, ok = oldData.(*ProjectZeroRssFeed); !ok {
+		return nil, errors.New("error: oldData is not of type ProjectZeroRssFeed")
+	}
+	if newPZData, ok = newData.(*ProjectZeroRssFeed); !ok {
+		return nil, errors.New("error: newData is not of type ProjectZeroRssFeed")
 	}
 
-	if len(newContent) > 20 {
-		return nil, errors.New("err: more than 20 new items. Logic bug likely. Exiting")
+	newContent, updatedContent := diffRssItems(oldPZData.messageData(), newPZData.messageData())
+	for _, item := range newContent {
+		slog.Debug("new article", "title", item.Title, "item", item.itemKey())
 	}
+
+	newContent = append(newContent, updatedContent...)
 	return newContent, nil
 }
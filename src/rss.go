@@ -4,42 +4,180 @@ Handles the querying of RSS feeds and the logic for handing off to the relevant
 package main
 
 import (
+	"bytes"
+	"context"
+	"encoding/xml"
 	"errors"
 	"fmt"
 	"io"
-	"log"
+	"log/slog"
 	"net/http"
+	"strings"
+	"time"
 )
 
+const (
+	// real feeds are a few hundred KB at most, anything much bigger is a broken or hostile server
+	feedSizeCap = 10 << 20
+	// items sit a handful of elements deep, so a document nested far deeper than this isn't a feed
+	feedDepthCap = 64
+)
+
+const defaultMaxItemAgeDays = 30
+
+// new items published longer ago than this are skipped rather than posted, zero turns the check off
+var maxItemAge = defaultMaxItemAgeDays * 24 * time.Hour
+
 // RSSFeed base interface for all the RSS structs and routines
 type RSSFeed interface {
-	ParseNewRssContent(oldData RSSFeed, newData RSSFeed) ([]discordMessageData, error)
+	// ParseNewRssContent returns the items to post. ctx is cancelled when the bot shuts down, so any further
+	// requests the parser makes should use it
+	ParseNewRssContent(ctx context.Context, oldData RSSFeed, newData RSSFeed) ([]discordMessageData, error)
+	// messageData converts every item currently in the feed, newest first as the outlet lists them
+	messageData() []discordMessageData
 }
 
-func parseNewRssContent(oldData RSSFeed, newData RSSFeed) ([]discordMessageData, error) {
+func parseNewRssContent(ctx context.Context, oldData RSSFeed, newData RSSFeed) (items []discordMessageData, err error) {
 	/*
-	   call the relevant routine based on the type of RSSFeed interface
+	   call the relevant routine based on the type of RSSFeed interface. Feeds are remote content, so a parser
+	   tripping over one is turned into an error rather than taking the whole bot down
 	*/
-	return oldData.ParseNewRssContent(oldData, newData)
+	defer func() {
+		if r := recover(); r != nil {
+			items, err = nil, fmt.Errorf("err: parser panicked - %v", r)
+		}
+	}()
+	return oldData.ParseNewRssContent(ctx, oldData, newData)
 }
 
-func queryRssFeed(feedUrl string) (pageData []byte, err error) {
+func diffRssItems(oldItems []discordMessageData, newItems []discordMessageData) (added []discordMessageData, updated []discordMessageData) {
 	/*
-	   Queries the RSS feed and returns the response body as a byte array
+		Compare two snapshots of a feed. Items whose identity is missing from the old snapshot are new, items that
+		exist in both but have had their title, summary or full text changed by the outlet, or carry a later updated
+		time, are returned as updates.
+	*/
+	oldByKey := make(map[string]discordMessageData, len(oldItems))
+	for _, item := range oldItems {
+		oldByKey[item.itemKey()] = item
+	}
+
+	for _, item := range newItems {
+		oldItem, ok := oldByKey[item.itemKey()]
+		if !ok {
+			added = append(added, item)
+			continue
+		}
+		if len(item.changes(oldItem.Title, oldItem.Description, oldItem.ContentHash)) > 0 || item.Modified.After(oldItem.Modified) {
+			item.Updated = true
+			updated = append(updated, item)
+		}
+	}
+	return
+}
+
+// the date formats seen in the wild, RFC 822 and 1123 with and without the day name, seconds and numeric zones, and
+// RFC 3339 with and without a zone
+var feedDateLayouts = []string{
+	time.RFC1123Z,
+	time.RFC1123,
+	"Mon, 2 Jan 2006 15:04:05 -0700",
+	"Mon, 2 Jan 2006 15:04:05 MST",
+	"Mon, 2 Jan 2006 15:04 -0700",
+	"Mon, 2 Jan 2006 15:04 MST",
+	"2 Jan 2006 15:04:05 -0700",
+	"2 Jan 2006 15:04:05 MST",
+	"2 Jan 2006 15:04 -0700",
+	"2 Jan 2006 15:04 MST",
+	"Mon, 2 Jan 06 15:04:05 -0700",
+	"Mon, 2 Jan 06 15:04:05 MST",
+	"2 Jan 06 15:04 -0700",
+	"2 Jan 06 15:04 MST",
+	"Mon, 2 Jan 2006 15:04:05 -07:00",
+	"Monday, 2-Jan-06 15:04:05 -0700",
+	"Monday, 2-Jan-06 15:04:05 MST",
+	time.RFC3339,
+	"2006-01-02T15:04:05",
+	"2006-01-02 15:04:05 -0700",
+	"2006-01-02 15:04:05",
+	time.DateOnly,
+}
+
+var rfc822Zones = map[string]string{
+	"UT": "+0000", "GMT": "+0000", "Z": "+0000",
+	"EST": "-0500", "EDT": "-0400",
+	"CST": "-0600", "CDT": "-0500",
+	"MST": "-0700", "MDT": "-0600",
+	"PST": "-0800", "PDT": "-0700",
+}
+
+func parseFeedDate(text string) time.Time {
+	/*
+		Dates from RSS feeds are meant to be RFC 822, and Atom ones RFC 3339, but not every outlet follows its own
+		format. Dates without a zone are taken as UTC. Returns the zero time for anything that can't be read.
+	*/
+	text = strings.Join(strings.Fields(text), " ")
+	if text == "" {
+		return time.Time{}
+	}
+	// zone names Go can't place are read as UTC, so swap the ones RFC 822 defines for their offsets, and drop the
+	// "(UTC)" some outlets put after a numeric zone
+	text = strings.TrimSuffix(strings.TrimSuffix(text, " (UTC)"), " (GMT)")
+	if idx := strings.LastIndex(text, " "); idx > 0 {
+		if offset, ok := rfc822Zones[text[idx+1:]]; ok {
+			text = text[:idx+1] + offset
+		}
+	}
+	for _, layout := range feedDateLayouts {
+		if parsed, err := time.Parse(layout, text); err == nil {
+			return parsed
+		}
+	}
+	return time.Time{}
+}
+
+func dropOldItems(items []discordMessageData) []discordMessageData {
+	/*
+		Skip new items published before maxItemAge ago, so an outlet republishing its archive doesn't flood the
+		channel. Edits to articles we've posted, and items without a date, always go through.
+	*/
+	if maxItemAge <= 0 {
+		return items
+	}
+	cutoff := time.Now().Add(-maxItemAge)
+	var recent []discordMessageData
+	for _, item := range items {
+		if !item.Updated && !item.Published.IsZero() && item.Published.Before(cutoff) {
+			slog.Info("skipping old item", "item", item.itemKey(), "title", item.Title, "published", item.Published)
+			continue
+		}
+		recent = append(recent, item)
+	}
+	return recent
+}
+
+func queryRssFeed(ctx context.Context, feedUrl string) (pageData []byte, statusCode int, err error) {
+	/*
+	   Queries the RSS feed and returns the response body as a byte array, and the status code, which is 0 when the
+	   request failed. Any URL an admin tries out comes through here, so metrics are left to the feed monitor.
 	*/
 	var (
+		request  *http.Request
 		response *http.Response
 	)
 
-	if response, err = http.Get(feedUrl); err != nil {
-		log.Println("err:", err)
+	if request, err = http.NewRequestWithContext(ctx, http.MethodGet, feedUrl, nil); err != nil {
+		return
+	}
+	if response, err = http.DefaultClient.Do(request); err != nil {
 		err = fmt.Errorf("error when quering RSS feed: %v\n", err)
 		return
 	}
+	defer response.Body.Close()
+	statusCode = response.StatusCode
 
 	if response.StatusCode == 429 {
 		// rate limited sites like ZDI return this.
-		log.Printf("Got response 429 from server %v. Will try again on next iteration.\n", feedUrl)
+		slog.Warn("rate limited, will try again on next iteration", "feed", feedUrl)
 		err = errors.New("err: got response 429 from server")
 		return
 	} else if response.StatusCode != 200 {
@@ -47,6 +185,61 @@ func queryRssFeed(feedUrl string) (pageData []byte, err error) {
 		return
 	}
 
-	pageData, err = io.ReadAll(response.Body)
+	pageData, err = io.ReadAll(io.LimitReader(response.Body, feedSizeCap+1))
+	if err == nil && len(pageData) > feedSizeCap {
+		pageData, err = nil, fmt.Errorf("err: feed %v is larger than %d bytes", feedUrl, feedSizeCap)
+	}
 	return
 }
+
+func fetchFeed(ctx context.Context, feedUrl string, newFeed RSSFeedFactory) (RSSFeed, error) {
+	/*
+		Query a feed and unmarshal it in one go, for the places that want a one-off snapshot rather than polling
+	*/
+	pageData, _, err := queryRssFeed(ctx, feedUrl)
+	if err != nil {
+		return nil, err
+	}
+
+	return unmarshalFeed(pageData, newFeed)
+}
+
+func unmarshalFeed(pageData []byte, newFeed RSSFeedFactory) (RSSFeed, error) {
+	/*
+		Unmarshal a feed, refusing documents that are too big or too deeply nested to be a real feed before the
+		parser's structs are filled in
+	*/
+	if len(pageData) > feedSizeCap {
+		return nil, fmt.Errorf("err: feed is larger than %d bytes", feedSizeCap)
+	}
+	if err := checkFeedDepth(pageData); err != nil {
+		return nil, err
+	}
+
+	feed := newFeed()
+	if err := xml.Unmarshal(pageData, &feed); err != nil {
+		return nil, fmt.Errorf("err: unmarshaling XML - %v", err)
+	}
+	return feed, nil
+}
+
+func checkFeedDepth(pageData []byte) error {
+	decoder := xml.NewDecoder(bytes.NewReader(pageData))
+	depth := 0
+	for {
+		token, err := decoder.RawToken()
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return fmt.Errorf("err: unmarshaling XML - %v", err)
+		}
+		switch token.(type) {
+		case xml.StartElement:
+			if depth++; depth > feedDepthCap {
+				return fmt.Errorf("err: feed is nested more than %d elements deep", feedDepthCap)
+			}
+		case xml.EndElement:
+			depth--
+		}
+	}
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func fuzzFeed(f *testing.F, feedType string) {
+	/*
+		Throw arbitrary documents at a feed type's structs and parser, seeded with its fixtures. Anything may be
+		rejected with an error, but nothing may panic. The parser runs with a cancelled context so it can't reach
+		the network.
+	*/
+	newFeed := feedTypes[feedType]
+	fixtures, _ := filepath.Glob(filepath.Join(fixtureDir, "*", "*.xml"))
+	var baseline RSSFeed
+	for _, fixture := range fixtures {
+		if guessFeedType(fixture) != feedType {
+			continue
+		}
+		pageData, err := os.ReadFile(fixture)
+		if err != nil {
+			f.Fatal(err)
+		}
+		f.Add(pageData)
+		if baseline == nil {
+			if baseline, err = unmarshalFeed(pageData, newFeed); err != nil {
+				f.Fatal(err)
+			}
+		}
+	}
+	f.Add([]byte(`<?xml version="1.0"?><rss><channel><item><title>`))
+	f.Add([]byte(`<feed xmlns="http://www.w3.org/2005/Atom"><entry><link href="%zz"/></entry></feed>`))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	f.Fuzz(func(t *testing.T, pageData []byte) {
+		feed, err := unmarshalFeed(pageData, newFeed)
+		if err != nil {
+			return
+		}
+		for _, item := range feed.messageData() {
+			item.itemKey()
+			newsEmbed(item)
+		}
+		if baseline != nil {
+			parseNewRssContent(ctx, baseline, feed)
+			parseNewRssContent(ctx, feed, baseline)
+		}
+	})
+}
+
+func FuzzHackerNewsFeed(f *testing.F) {
+	fuzzFeed(f, "hackernews")
+}
+
+func FuzzProjectZeroFeed(f *testing.F) {
+	fuzzFeed(f, "projectzero")
+}
+
+func FuzzZDIFeed(f *testing.F) {
+	fuzzFeed(f, "zdi")
+}
+
+func FuzzPortSwiggerFeed(f *testing.F) {
+	fuzzFeed(f, "portswigger")
+}
+
+func FuzzPageCategories(f *testing.F) {
+	pages, _ := filepath.Glob(filepath.Join(fixtureDir, "hackernews", "pages", "*", "*", "*.html"))
+	for _, page := range pages {
+		body, err := os.ReadFile(page)
+		if err != nil {
+			f.Fatal(err)
+		}
+		f.Add(string(body))
+	}
+	f.Add("<span class='p-tags'>")
+	f.Add("<span class='p-tags'></span></span>")
+
+	hn := &HackerNewsRssFeed{}
+	f.Fuzz(func(t *testing.T, body string) {
+		category := parsePageCategories(body)
+		if len(category) > len(body) {
+			t.Fatalf("scraped %d bytes of categories from a %d byte page", len(category), len(body))
+		}
+		hn.interestingCats(category)
+	})
+}
@@ -0,0 +1,304 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"flag"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+var updateGolden = flag.Bool("update", false, "rewrite the golden files in rss_tests from the parsers' current output")
+
+const fixtureDir = "../rss_tests"
+
+// goldenStep is one old → new transition of a fixture feed, and what the parser found in it
+type goldenStep struct {
+	From  string               `json:"from"`
+	To    string               `json:"to"`
+	Items []discordMessageData `json:"items"`
+}
+
+// fixtureTransport sends every request to the fixture server. Requests for other hosts, like the article pages The
+// Hacker News parser scrapes, are served from the fixture's pages directory by path.
+type fixtureTransport struct {
+	server *httptest.Server
+}
+
+func (ft fixtureTransport) RoundTrip(request *http.Request) (*http.Response, error) {
+	serverUrl, _ := url.Parse(ft.server.URL)
+	if request.URL.Host != serverUrl.Host {
+		request = request.Clone(request.Context())
+		request.URL.Path = "/pages" + request.URL.Path
+		request.URL.Scheme, request.URL.Host, request.Host = serverUrl.Scheme, serverUrl.Host, serverUrl.Host
+	}
+	return ft.server.Client().Transport.RoundTrip(request)
+}
+
+func serveFixture(t *testing.T, dir string) *httptest.Server {
+	/*
+		Serve a fixture directory over HTTP, and route the bot's outgoing requests to it for the rest of the test
+	*/
+	t.Helper()
+	server := httptest.NewServer(http.FileServer(http.Dir(dir)))
+	t.Cleanup(server.Close)
+
+	oldTransport := http.DefaultClient.Transport
+	http.DefaultClient.Transport = fixtureTransport{server: server}
+	t.Cleanup(func() { http.DefaultClient.Transport = oldTransport })
+	return server
+}
+
+func fixtureSnapshots(dir string) []string {
+	/*
+		Snapshots of a feed in the order they were taken, the old*.xml files followed by the new*.xml ones, the
+		same as replay uses
+	*/
+	oldFiles, _ := filepath.Glob(filepath.Join(dir, "old*.xml"))
+	newFiles, _ := filepath.Glob(filepath.Join(dir, "new*.xml"))
+	return append(oldFiles, newFiles...)
+}
+
+func TestFeedGoldenFiles(t *testing.T) {
+	dirs, err := os.ReadDir(fixtureDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, entry := range dirs {
+		if !entry.IsDir() {
+			continue
+		}
+		dir := filepath.Join(fixtureDir, entry.Name())
+		t.Run(entry.Name(), func(t *testing.T) {
+			newFeed, feedType, err := feedTypeFor("", dir)
+			if err != nil {
+				t.Fatal(err)
+			}
+			snapshots := fixtureSnapshots(dir)
+			if len(snapshots) < 2 {
+				t.Fatalf("%s needs at least one old*.xml and one new*.xml snapshot", dir)
+			}
+			server := serveFixture(t, dir)
+
+			fetch := func(snapshot string) RSSFeed {
+				feed, err := fetchFeed(context.Background(), server.URL+"/"+filepath.Base(snapshot), newFeed)
+				if err != nil {
+					t.Fatal(err)
+				}
+				return feed
+			}
+
+			var steps []goldenStep
+			previous := fetch(snapshots[0])
+			for idx, snapshot := range snapshots[1:] {
+				current := fetch(snapshot)
+				items, err := parseNewRssContent(context.Background(), previous, current)
+				if err != nil {
+					t.Fatalf("parsing %s: %v", snapshot, err)
+				}
+				for itemIdx := range items {
+					items[itemIdx].Source = feedType
+				}
+				// output that can't be right fails whatever the golden file says, so -update can't approve it
+				checkParserOutput(t, snapshot, current, items)
+				steps = append(steps, goldenStep{From: filepath.Base(snapshots[idx]), To: filepath.Base(snapshot), Items: items})
+				previous = current
+			}
+
+			got, err := json.MarshalIndent(steps, "", "  ")
+			if err != nil {
+				t.Fatal(err)
+			}
+			got = append(got, '\n')
+
+			goldenFile := filepath.Join(dir, "golden.json")
+			if *updateGolden {
+				if err = os.WriteFile(goldenFile, got, 0o644); err != nil {
+					t.Fatal(err)
+				}
+				return
+			}
+			want, err := os.ReadFile(goldenFile)
+			if err != nil {
+				t.Fatalf("%v, run the tests with -update to create it", err)
+			}
+			if !bytes.Equal(got, want) {
+				t.Errorf("parser output for %s doesn't match %s, run the tests with -update if the change is intended\n%s",
+					dir, goldenFile, lineDiff(string(want), string(got)))
+			}
+		})
+	}
+}
+
+func checkParserOutput(t *testing.T, snapshot string, feed RSSFeed, items []discordMessageData) {
+	/*
+		Every item in a snapshot needs its own key, or new items are mistaken for edits of each other, and every item
+		posted needs a link readers can follow
+	*/
+	t.Helper()
+	keys := make(map[string]bool)
+	for _, item := range feed.messageData() {
+		if keys[item.itemKey()] {
+			t.Errorf("%s has more than one item with the key %q", snapshot, item.itemKey())
+		}
+		keys[item.itemKey()] = true
+	}
+	for _, item := range items {
+		if parsed, err := url.Parse(item.Link); err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+			t.Errorf("%s: %q has the link %q, which isn't a web address", snapshot, item.Title, item.Link)
+		}
+	}
+}
+
+func lineDiff(want string, got string) string {
+	/*
+		A rough diff of the first lines that differ, enough to see what changed without a diff library
+	*/
+	wantLines, gotLines := strings.Split(want, "\n"), strings.Split(got, "\n")
+	var diff []string
+	for idx := 0; idx < len(wantLines) || idx < len(gotLines); idx++ {
+		var wantLine, gotLine string
+		if idx < len(wantLines) {
+			wantLine = wantLines[idx]
+		}
+		if idx < len(gotLines) {
+			gotLine = gotLines[idx]
+		}
+		if wantLine == gotLine {
+			continue
+		}
+		diff = append(diff, "- "+wantLine, "+ "+gotLine)
+		if len(diff) >= 20 {
+			diff = append(diff, "...")
+			break
+		}
+	}
+	return strings.Join(diff, "\n")
+}
+
+func TestUnmarshalFeedRejectsHostileDocuments(t *testing.T) {
+	newFeed := feedTypes["zdi"]
+	documents := map[string][]byte{
+		"truncated": []byte(`<rss><channel><item><title>cut off`),
+		"too large": append([]byte(`<rss><channel><description>`), bytes.Repeat([]byte("a"), feedSizeCap)...),
+		"too deep":  []byte(strings.Repeat("<a>", feedDepthCap+1) + strings.Repeat("</a>", feedDepthCap+1)),
+		"not xml":   []byte("\x00\xff{}"),
+	}
+	for name, document := range documents {
+		if _, err := unmarshalFeed(document, newFeed); err == nil {
+			t.Errorf("%s document was accepted", name)
+		}
+	}
+}
+
+func TestQueryRssFeedSizeCap(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write(bytes.Repeat([]byte("a"), feedSizeCap+1))
+	}))
+	defer server.Close()
+
+	if _, _, err := queryRssFeed(context.Background(), server.URL); err == nil || !strings.Contains(err.Error(), "larger than") {
+		t.Errorf("expected an oversized feed to be refused, got %v", err)
+	}
+}
+
+// panickingFeed stands in for a parser with a bug that a malformed feed trips over
+type panickingFeed struct{}
+
+func (pf *panickingFeed) ParseNewRssContent(ctx context.Context, oldData RSSFeed, newData RSSFeed) ([]discordMessageData, error) {
+	var items []discordMessageData
+	return []discordMessageData{items[0]}, nil
+}
+
+func (pf *panickingFeed) messageData() []discordMessageData {
+	return nil
+}
+
+func TestParserPanicBecomesError(t *testing.T) {
+	if _, err := parseNewRssContent(context.Background(), &panickingFeed{}, &panickingFeed{}); err == nil {
+		t.Error("expected the parser's panic to be returned as an error")
+	}
+}
+
+func TestParseFeedDate(t *testing.T) {
+	want := time.Date(2023, 5, 23, 16, 45, 0, 0, time.UTC)
+	dates := []string{
+		"Tue, 23 May 2023 16:45:00 +0000",
+		"Tue, 23 May 2023 16:45:00 GMT",
+		"Tue, 23 May 2023 12:45:00 EDT",
+		"Tue, 23 May 2023 09:45:00 PDT",
+		"Tue, 23 May 2023 16:45:00 +0000 (UTC)",
+		"Tue,  23 May 2023\n16:45:00 +0000",
+		"23 May 2023 16:45:00 +0000",
+		"Tue, 23 May 2023 16:45 GMT",
+		"Tue, 23 May 23 16:45:00 +0000",
+		"Tue, 23 May 2023 18:45:00 +02:00",
+		"Tuesday, 23-May-23 16:45:00 GMT",
+		"2023-05-23T16:45:00Z",
+		"2023-05-23T18:45:00+02:00",
+		"2023-05-23T16:45:00",
+		"2023-05-23 16:45:00",
+	}
+	for _, date := range dates {
+		if parsed := parseFeedDate(date); !parsed.Equal(want) {
+			t.Errorf("parseFeedDate(%q) = %v, want %v", date, parsed, want)
+		}
+	}
+	for _, date := range []string{"", "yesterday", "Tue, 32 May 2023 16:45:00 +0000"} {
+		if parsed := parseFeedDate(date); !parsed.IsZero() {
+			t.Errorf("parseFeedDate(%q) = %v, want the zero time", date, parsed)
+		}
+	}
+}
+
+func TestDropOldItems(t *testing.T) {
+	defer func(age time.Duration) { maxItemAge = age }(maxItemAge)
+	maxItemAge = 24 * time.Hour
+
+	items := []discordMessageData{
+		{ID: "recent", Published: time.Now().Add(-time.Hour)},
+		{ID: "old", Published: time.Now().Add(-48 * time.Hour)},
+		{ID: "old-edit", Published: time.Now().Add(-48 * time.Hour), Updated: true},
+		{ID: "undated"},
+	}
+	var kept []string
+	for _, item := range dropOldItems(items) {
+		kept = append(kept, item.ID)
+	}
+	if strings.Join(kept, ",") != "recent,old-edit,undated" {
+		t.Errorf("kept %v", kept)
+	}
+
+	maxItemAge = 0
+	if kept := dropOldItems(items); len(kept) != len(items) {
+		t.Errorf("expected nothing dropped with the check off, kept %d of %d", len(kept), len(items))
+	}
+}
+
+func TestPageCategoriesStopAtTheirSpan(t *testing.T) {
+	// an article page whose tags are followed by other spans on the same line, one of which names an interesting tag
+	body := `<div class='postmeta'><span class='p-tags'>Privacy / Online Advertising</span><span class='p-author'>Malware Desk</span></div>`
+	if category := parsePageCategories(body); category != ">Privacy / Online Advertising" {
+		t.Errorf("scraped %q", category)
+	}
+	if cats := (&HackerNewsRssFeed{}).interestingCats(parsePageCategories(body)); len(cats) != 0 {
+		t.Errorf("text after the tags was taken for a category, got %v", cats)
+	}
+}
+
+func TestPortSwiggerItemWithoutCategory(t *testing.T) {
+	if categories := (PortSwiggerItem{GUID: "1", Title: "No category"}).messageData().Categories; categories != nil {
+		t.Errorf("expected no categories, got %q", categories)
+	}
+	if categories := (PortSwiggerItem{GUID: "2", Category: "Research"}).messageData().Categories; len(categories) != 1 || categories[0] != "Research" {
+		t.Errorf("expected the item's category, got %q", categories)
+	}
+}
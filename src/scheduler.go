@@ -0,0 +1,209 @@
+/*
+The feed scheduler owns every feed's polling job. Each job waits on its own timer, or a poll now trigger, and then
+hands the poll to a fixed pool of workers, so however many feeds are configured only a few fetches run at once. Jobs
+are cancelled through their context when the feed is removed or the bot shuts down.
+*/
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"sort"
+	"sync"
+	"time"
+)
+
+const defaultMaxConcurrentFetches = 4
+
+// feedScheduler polls every feed every interval, with at most workers polls in progress at a time
+type feedScheduler struct {
+	ctx      context.Context
+	interval time.Duration
+	queue    chan pollRequest
+
+	mu   sync.Mutex
+	jobs map[string]*feedMonitor
+	// the jobs and the workers, so shutdown can wait for them to finish what they're doing
+	running sync.WaitGroup
+	// set once shutdown has started waiting on running, after which no more jobs can be added
+	stopped bool
+}
+
+type pollRequest struct {
+	monitor *feedMonitor
+	done    chan struct{}
+}
+
+var scheduler *feedScheduler
+
+func newFeedScheduler(ctx context.Context, interval time.Duration, workers int) *feedScheduler {
+	sched := &feedScheduler{
+		ctx:      ctx,
+		interval: interval,
+		queue:    make(chan pollRequest),
+		jobs:     map[string]*feedMonitor{},
+	}
+	for idx := 0; idx < workers; idx++ {
+		sched.running.Add(1)
+		go sched.worker()
+	}
+	return sched
+}
+
+func (sched *feedScheduler) worker() {
+	defer sched.running.Done()
+	for {
+		select {
+		case <-sched.ctx.Done():
+			return
+		case req := <-sched.queue:
+			req.monitor.poll()
+			close(req.done)
+		}
+	}
+}
+
+func (sched *feedScheduler) runJob(monitor *feedMonitor) {
+	/*
+		Poll the feed as soon as the job starts and then every interval, until the job is cancelled or the feed
+		stops after an error. Paused feeds keep their timer but skip the poll.
+	*/
+	defer sched.running.Done()
+
+	timer := time.NewTimer(0)
+	defer timer.Stop()
+	for {
+		select {
+		case <-monitor.ctx.Done():
+			monitor.log.Info("monitor cancelled")
+			return
+		case <-timer.C:
+		case <-monitor.pollNow:
+			if !timer.Stop() {
+				select {
+				case <-timer.C:
+				default:
+				}
+			}
+		}
+
+		if !monitor.isPaused() {
+			req := pollRequest{monitor: monitor, done: make(chan struct{})}
+			select {
+			case sched.queue <- req:
+				<-req.done
+			case <-monitor.ctx.Done():
+				continue
+			}
+			if !monitor.snapshot().Running {
+				return
+			}
+		}
+		timer.Reset(sched.interval)
+	}
+}
+
+func (sched *feedScheduler) add(feed feedConfig) (*feedMonitor, error) {
+	/*
+		Start a job for a feed. If the feed already has a job but it stopped after an error, it's replaced with a
+		fresh one.
+	*/
+	newFeed, ok := feedTypes[feed.Type]
+	if !ok {
+		return nil, fmt.Errorf("err: unknown feed type '%v'", feed.Type)
+	}
+
+	sched.mu.Lock()
+	defer sched.mu.Unlock()
+
+	if sched.stopped {
+		return nil, fmt.Errorf("err: shutting down, not starting %v", feed.URL)
+	}
+	if existing, ok := sched.jobs[feed.URL]; ok && existing.snapshot().Running {
+		return existing, nil
+	}
+
+	monitor := &feedMonitor{
+		url:      feed.URL,
+		feedType: feed.Type,
+		newFeed:  newFeed,
+		log:      slog.With("feed", feed.URL, "type", feed.Type),
+		pollNow:  make(chan struct{}, 1),
+		paused:   feed.Paused,
+		running:  true,
+		// a feed we've never seen an item from gets the full staleness threshold from now
+		lastNewItem: feed.LastItemAt,
+	}
+	if monitor.lastNewItem.IsZero() {
+		monitor.lastNewItem = time.Now()
+	}
+	monitor.ctx, monitor.cancel = context.WithCancel(sched.ctx)
+	sched.jobs[feed.URL] = monitor
+	sched.running.Add(1)
+	go sched.runJob(monitor)
+	return monitor, nil
+}
+
+func (sched *feedScheduler) stop() {
+	/*
+		Refuse any more jobs, so nothing is added to running while shutdown waits on it
+	*/
+	sched.mu.Lock()
+	defer sched.mu.Unlock()
+	sched.stopped = true
+}
+
+func (sched *feedScheduler) remove(url string) {
+	sched.mu.Lock()
+	defer sched.mu.Unlock()
+
+	if monitor, ok := sched.jobs[url]; ok {
+		monitor.cancel()
+		delete(sched.jobs, url)
+		clearFeedAlerts(url)
+	}
+}
+
+func (sched *feedScheduler) lookup(url string) (*feedMonitor, bool) {
+	sched.mu.Lock()
+	defer sched.mu.Unlock()
+
+	monitor, ok := sched.jobs[url]
+	return monitor, ok
+}
+
+func (sched *feedScheduler) statuses() []feedMonitorStatus {
+	sched.mu.Lock()
+	statuses := make([]feedMonitorStatus, 0, len(sched.jobs))
+	for _, monitor := range sched.jobs {
+		statuses = append(statuses, monitor.snapshot())
+	}
+	sched.mu.Unlock()
+
+	sort.Slice(statuses, func(a, b int) bool { return statuses[a].URL < statuses[b].URL })
+	return statuses
+}
+
+func (sched *feedScheduler) triggerPoll(url string) error {
+	/*
+		Poll a feed now instead of waiting for its timer. Returns once the poll is queued, not when it's done.
+	*/
+	monitor, ok := sched.lookup(url)
+	if !ok {
+		return fmt.Errorf("err: not monitoring %v", url)
+	}
+	feedStatus := monitor.snapshot()
+	if !feedStatus.Running {
+		return fmt.Errorf("err: %v has stopped, resume it first", url)
+	} else if feedStatus.Paused {
+		return fmt.Errorf("err: %v is paused, resume it first", url)
+	}
+
+	select {
+	case monitor.pollNow <- struct{}{}:
+	default:
+		// a poll is already waiting to happen
+	}
+	return nil
+}
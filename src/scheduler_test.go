@@ -0,0 +1,205 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// testOutlet serves the ZDI fixture at every path, counting requests and how many are in progress at once. While
+// hold is open each request waits for it to be closed, or for the client to give up.
+type testOutlet struct {
+	*httptest.Server
+	requests    atomic.Int32
+	inFlight    atomic.Int32
+	maxInFlight atomic.Int32
+	arrived     chan string
+	cancelled   chan string
+
+	holdMu sync.Mutex
+	hold   chan struct{}
+}
+
+func startTestOutlet(t *testing.T) *testOutlet {
+	t.Helper()
+	feed, err := os.ReadFile("../rss_tests/zdi/oldfeed.xml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	outlet := &testOutlet{arrived: make(chan string, 100), cancelled: make(chan string, 100)}
+	outlet.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		outlet.requests.Add(1)
+		current := outlet.inFlight.Add(1)
+		defer outlet.inFlight.Add(-1)
+		for max := outlet.maxInFlight.Load(); current > max && !outlet.maxInFlight.CompareAndSwap(max, current); max = outlet.maxInFlight.Load() {
+		}
+		outlet.arrived <- r.URL.Path
+
+		outlet.holdMu.Lock()
+		hold := outlet.hold
+		outlet.holdMu.Unlock()
+		if hold != nil {
+			select {
+			case <-hold:
+			case <-r.Context().Done():
+				outlet.cancelled <- r.URL.Path
+				return
+			}
+		}
+		w.Write(feed)
+	}))
+	t.Cleanup(outlet.Close)
+	return outlet
+}
+
+func (outlet *testOutlet) holdRequests() {
+	outlet.holdMu.Lock()
+	outlet.hold = make(chan struct{})
+	outlet.holdMu.Unlock()
+}
+
+func (outlet *testOutlet) release() {
+	outlet.holdMu.Lock()
+	if outlet.hold != nil {
+		close(outlet.hold)
+		outlet.hold = nil
+	}
+	outlet.holdMu.Unlock()
+}
+
+func (outlet *testOutlet) waitForRequest(t *testing.T) string {
+	t.Helper()
+	select {
+	case path := <-outlet.arrived:
+		return path
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for the feed to be fetched")
+		return ""
+	}
+}
+
+// startTestScheduler starts a scheduler that only polls feeds when they're added or triggered
+func startTestScheduler(t *testing.T, workers int) *feedScheduler {
+	t.Helper()
+	ctx, cancel := context.WithCancel(context.Background())
+	sched := newFeedScheduler(ctx, time.Hour, workers)
+	t.Cleanup(func() {
+		cancel()
+		if !waitTimeout(&sched.running, time.Now().Add(5*time.Second)) {
+			t.Error("scheduler didn't stop")
+		}
+	})
+	return sched
+}
+
+func TestSchedulerBoundsConcurrentFetches(t *testing.T) {
+	setupFakeDiscord(t)
+	outlet := startTestOutlet(t)
+	outlet.holdRequests()
+	defer outlet.release()
+	sched := startTestScheduler(t, 2)
+
+	for _, path := range []string{"/a", "/b", "/c", "/d", "/e"} {
+		if _, err := sched.add(feedConfig{URL: outlet.URL + path, Type: "zdi"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	outlet.waitForRequest(t)
+	outlet.waitForRequest(t)
+	// give the other feeds a chance to jump the queue if they're going to
+	time.Sleep(100 * time.Millisecond)
+	if inFlight := outlet.inFlight.Load(); inFlight != 2 {
+		t.Errorf("expected 2 fetches at once, got %d", inFlight)
+	}
+
+	outlet.release()
+	for idx := 0; idx < 3; idx++ {
+		outlet.waitForRequest(t)
+	}
+	if max := outlet.maxInFlight.Load(); max != 2 {
+		t.Errorf("at most 2 fetches should run at once, %d did", max)
+	}
+}
+
+func TestSchedulerPauseResumeAndTrigger(t *testing.T) {
+	setupFakeDiscord(t)
+	outlet := startTestOutlet(t)
+	sched := startTestScheduler(t, 1)
+	feedUrl := outlet.URL + "/paused"
+
+	if err := sched.triggerPoll(feedUrl); err == nil {
+		t.Error("expected a poll of an unknown feed to be refused")
+	}
+	monitor, err := sched.add(feedConfig{URL: feedUrl, Type: "zdi", Paused: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = sched.triggerPoll(feedUrl); err == nil {
+		t.Error("expected a poll of a paused feed to be refused")
+	}
+	time.Sleep(100 * time.Millisecond)
+	if requests := outlet.requests.Load(); requests != 0 {
+		t.Fatalf("a paused feed was fetched %d times", requests)
+	}
+
+	monitor.setPaused(false)
+	if err = sched.triggerPoll(feedUrl); err != nil {
+		t.Fatal(err)
+	}
+	outlet.waitForRequest(t)
+	if err = sched.triggerPoll(feedUrl); err != nil {
+		t.Fatal(err)
+	}
+	outlet.waitForRequest(t)
+
+	// adding a running feed again leaves its job alone
+	if again, _ := sched.add(feedConfig{URL: feedUrl, Type: "zdi"}); again != monitor {
+		t.Error("a running feed's job was replaced")
+	}
+}
+
+func TestSchedulerRemoveCancelsFetch(t *testing.T) {
+	setupFakeDiscord(t)
+	outlet := startTestOutlet(t)
+	outlet.holdRequests()
+	defer outlet.release()
+	sched := startTestScheduler(t, 1)
+	feedUrl := outlet.URL + "/removed"
+
+	monitor, err := sched.add(feedConfig{URL: feedUrl, Type: "zdi"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	outlet.waitForRequest(t)
+	sched.remove(feedUrl)
+
+	select {
+	case <-outlet.cancelled:
+	case <-time.After(5 * time.Second):
+		t.Fatal("removing the feed didn't cancel its fetch")
+	}
+	if _, ok := sched.lookup(feedUrl); ok {
+		t.Error("removed feed is still scheduled")
+	}
+	// a cancelled fetch isn't the feed's fault
+	time.Sleep(50 * time.Millisecond)
+	if status := monitor.snapshot(); status.Failures != 0 {
+		t.Errorf("cancelled fetch counted as a failure: %+v", status)
+	}
+}
+
+func TestSchedulerRefusesFeedsOnceStopped(t *testing.T) {
+	sched := startTestScheduler(t, 1)
+	sched.stop()
+	if _, err := sched.add(feedConfig{URL: "https://example.com/feed", Type: "zdi"}); err == nil {
+		t.Error("expected a feed added during shutdown to be refused")
+	}
+	if len(sched.statuses()) != 0 {
+		t.Error("a job was started during shutdown")
+	}
+}
@@ -0,0 +1,106 @@
+/*
+Graceful shutdown on SIGINT or SIGTERM. Cancelling botContext stops every feed loop and in-flight fetch, anything
+already being posted to Discord is given until the shutdown timeout to finish, and then the state is flushed, the slash
+commands optionally removed and the gateway closed.
+*/
+package main
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+const defaultShutdownTimeout = 30 * time.Second
+
+var (
+	// the parent of every feed loop's context, cancelled when the bot is asked to stop
+	botContext = context.Background()
+
+	postsMu      sync.Mutex
+	postsClosed  bool
+	postsRunning = &sync.WaitGroup{}
+)
+
+func beginPosting() *sync.WaitGroup {
+	/*
+		Register a batch of posts with the shutdown drain, returning what to call Done on when the batch is sent.
+		Returns nil once shutdown has started, when nothing new should be sent.
+	*/
+	postsMu.Lock()
+	defer postsMu.Unlock()
+	if postsClosed {
+		return nil
+	}
+	postsRunning.Add(1)
+	return postsRunning
+}
+
+func waitTimeout(wg *sync.WaitGroup, deadline time.Time) bool {
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return true
+	case <-time.After(time.Until(deadline)):
+		return false
+	}
+}
+
+func (st *stateStore) flush() error {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	return st.save()
+}
+
+func shutdown(timeout time.Duration, server *http.Server, registeredCommands []*discordgo.ApplicationCommand, deregister bool) {
+	/*
+		Called once botContext has been cancelled. Each step is best effort, a failure is logged and the rest of the
+		shutdown carries on.
+	*/
+	deadline := time.Now().Add(timeout)
+	slog.Info("shutting down", "timeout", timeout)
+
+	// the jobs notice the cancellation straight away unless they're part way through posting
+	scheduler.stop()
+	if !waitTimeout(&scheduler.running, deadline) {
+		slog.Warn("feed monitors didn't stop before the shutdown timeout")
+	}
+	postsMu.Lock()
+	postsClosed = true
+	posts := postsRunning
+	postsMu.Unlock()
+	if !waitTimeout(posts, deadline) {
+		slog.Warn("posts were still being sent at the shutdown timeout")
+	}
+
+	if err := store.flush(); err != nil {
+		slog.Error("saving state", "err", err)
+	}
+
+	if deregister {
+		for _, cmd := range registeredCommands {
+			if err := discordSession.ApplicationCommandDelete(discordSession.State.User.ID, serverId, cmd.ID); err != nil {
+				slog.Error("deleting application command", "command", cmd.Name, "err", err)
+			}
+		}
+	}
+
+	ctx, cancel := context.WithDeadline(context.Background(), deadline)
+	defer cancel()
+	if err := server.Shutdown(ctx); err != nil {
+		slog.Warn("stopping HTTP server", "err", err)
+	}
+
+	if err := discordSession.Close(); err != nil {
+		slog.Warn("closing Discord connection", "err", err)
+	}
+	slog.Info("shut down")
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+// slowClient holds up every send until it's released, like a Discord API call that's taking its time
+type slowClient struct {
+	*recordingClient
+	sending chan struct{}
+	release chan struct{}
+}
+
+func (c slowClient) sendMessage(channelID string, message *discordgo.MessageSend) (*discordgo.Message, error) {
+	c.sending <- struct{}{}
+	<-c.release
+	return c.recordingClient.sendMessage(channelID, message)
+}
+
+// startPostDuringShutdown begins posting an item through a slow client and returns once the send is under way
+func startPostDuringShutdown(t *testing.T) (slowClient, chan []string) {
+	t.Helper()
+	fake := setupFakeDiscord(t)
+	oldSession, oldScheduler := discordSession, scheduler
+	// a drain of its own, as a post left stuck by one test is still in the last one's
+	postsMu.Lock()
+	oldClosed, oldRunning := postsClosed, postsRunning
+	postsRunning = &sync.WaitGroup{}
+	postsMu.Unlock()
+	t.Cleanup(func() {
+		discordSession, scheduler = oldSession, oldScheduler
+		postsMu.Lock()
+		postsClosed, postsRunning = oldClosed, oldRunning
+		postsMu.Unlock()
+	})
+
+	session, err := discordgo.New("Bot test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	discordSession = session
+	ctx, cancel := context.WithCancel(context.Background())
+	scheduler = newFeedScheduler(ctx, time.Hour, 1)
+	cancel()
+
+	slow := slowClient{recordingClient: fake, sending: make(chan struct{}, 1), release: make(chan struct{})}
+	discord = slow
+	posted := make(chan []string, 1)
+	go func() {
+		posted <- submitNewRssContent([]discordMessageData{{ID: "in-flight", Title: "In flight", Link: "https://example.com/1"}})
+	}()
+	select {
+	case <-slow.sending:
+	case <-time.After(5 * time.Second):
+		t.Fatal("post never started")
+	}
+	return slow, posted
+}
+
+func TestShutdownWaitsForInFlightPost(t *testing.T) {
+	slow, posted := startPostDuringShutdown(t)
+	go func() {
+		time.Sleep(100 * time.Millisecond)
+		close(slow.release)
+	}()
+
+	start := time.Now()
+	shutdown(5*time.Second, &http.Server{}, nil, false)
+	if elapsed := time.Since(start); elapsed < 100*time.Millisecond || elapsed > 4*time.Second {
+		t.Errorf("shutdown took %v, expected it to wait for the post and no longer", elapsed)
+	}
+	if ids := <-posted; len(ids) != 1 {
+		t.Errorf("in-flight post didn't finish, got %v", ids)
+	}
+
+	// the post made it into the state file, and nothing new is posted once shutdown has started
+	reloaded, err := loadStateStore(store.path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := reloaded.lookupPost("in-flight"); !ok {
+		t.Error("post finished during shutdown wasn't saved")
+	}
+	if ids := submitNewRssContent([]discordMessageData{{ID: "late", Title: "Late", Link: "https://example.com/2"}}); len(ids) != 0 {
+		t.Errorf("posted after shutdown started: %v", ids)
+	}
+}
+
+func TestShutdownDoesNotWaitPastTimeout(t *testing.T) {
+	slow, posted := startPostDuringShutdown(t)
+	// let the stuck post go once the test is done with it
+	t.Cleanup(func() {
+		close(slow.release)
+		<-posted
+	})
+	// a change that hasn't been saved yet
+	store.mu.Lock()
+	store.data.FeedsSeeded = true
+	store.mu.Unlock()
+
+	start := time.Now()
+	shutdown(200*time.Millisecond, &http.Server{}, nil, false)
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("a stuck post held shutdown up for %v", elapsed)
+	}
+
+	// the state is still saved on the way out
+	reloaded, err := loadStateStore(store.path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reloaded.data.FeedsSeeded {
+		t.Error("state wasn't flushed after the timeout")
+	}
+}
@@ -0,0 +1,275 @@
+/*
+Persistent state for the bot. Everything the bot needs to remember between restarts is kept in a single JSON
+document on disk, which is rewritten whenever something changes.
+*/
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+const defaultStateFile = "botstate.json"
+
+// postedMessage records where an article ended up in Discord, so it can be edited or retracted later
+type postedMessage struct {
+	ItemID      string    `json:"item_id"`
+	ChannelID   string    `json:"channel_id"`
+	MessageID   string    `json:"message_id"`
+	Title       string    `json:"title"`
+	Description string    `json:"description"`
+	Link        string    `json:"link"`
+	Image       string    `json:"image,omitempty"`
+	Source      string    `json:"source,omitempty"`
+	Categories  []string  `json:"categories,omitempty"`
+	Tags        []string  `json:"tags,omitempty"`
+	CVEs        []string  `json:"cves,omitempty"`
+	Published   time.Time `json:"published,omitempty"`
+	Modified    time.Time `json:"modified,omitempty"`
+	ContentHash string    `json:"content_hash,omitempty"`
+	PostedAt    time.Time `json:"posted_at"`
+	EditedAt    time.Time `json:"edited_at,omitempty"`
+	Retracted   bool      `json:"retracted,omitempty"`
+}
+
+// feedConfig is a monitored feed, as added with /feed add or seeded from defaultFeeds
+type feedConfig struct {
+	URL     string    `json:"url"`
+	Type    string    `json:"type"`
+	Paused  bool      `json:"paused,omitempty"`
+	AddedBy string    `json:"added_by,omitempty"`
+	AddedAt time.Time `json:"added_at,omitempty"`
+	// when the feed last had an item we hadn't seen before, for spotting feeds that have gone stale
+	LastItemAt time.Time `json:"last_item_at,omitempty"`
+	// more new items than this in one poll are held for an admin to confirm, zero means defaultBurstLimit
+	BurstLimit int `json:"burst_limit,omitempty"`
+	// what to post from the first snapshot of the feed, see backfill.go. Backfilled is set once that's been done
+	Backfill      string    `json:"backfill,omitempty"`
+	BackfillCount int       `json:"backfill_count,omitempty"`
+	BackfillSince time.Time `json:"backfill_since,omitempty"`
+	Backfilled    bool      `json:"backfilled,omitempty"`
+	// what to do when the outlet changes an article that's been posted, see updates.go. Empty means updateEdit
+	UpdatePolicy string `json:"update_policy,omitempty"`
+	// only new items tagged with one of these topics are posted, see topics.go. Empty means everything
+	Topics []string `json:"topics,omitempty"`
+}
+
+type botState struct {
+	Posts       map[string]*postedMessage    `json:"posts"`
+	Feeds       map[string]*feedConfig       `json:"feeds"`
+	FeedsSeeded bool                         `json:"feeds_seeded"`
+	Suggestions map[string]*suggestion       `json:"suggestions"`
+	Permissions map[string]*guildPermissions `json:"permissions"`
+	// channel IDs that articles with a topic tag are posted to instead of the news channel
+	TopicRoutes map[string]string `json:"topic_routes,omitempty"`
+	// batches of new items waiting for an admin, see bursts.go
+	HeldBursts map[string]*heldBurst `json:"held_bursts,omitempty"`
+}
+
+type stateStore struct {
+	mu    sync.Mutex
+	path  string
+	data  botState
+	index archiveIndex
+	// changes are kept in memory but never written, for the dry-run and replay subcommands
+	readOnly bool
+}
+
+var store *stateStore
+
+func loadStateStore(path string) (*stateStore, error) {
+	/*
+		Read the state file from disk. A missing file is not an error, the bot just starts with an empty state.
+	*/
+	st := &stateStore{path: path, index: make(archiveIndex)}
+
+	contents, err := os.ReadFile(path)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return nil, err
+	}
+	if len(contents) > 0 {
+		if err = json.Unmarshal(contents, &st.data); err != nil {
+			return nil, err
+		}
+	}
+	if st.data.Posts == nil {
+		st.data.Posts = make(map[string]*postedMessage)
+	}
+	if st.data.Feeds == nil {
+		st.data.Feeds = make(map[string]*feedConfig)
+	}
+	if st.data.Suggestions == nil {
+		st.data.Suggestions = make(map[string]*suggestion)
+	}
+	if st.data.Permissions == nil {
+		st.data.Permissions = make(map[string]*guildPermissions)
+	}
+	if st.data.TopicRoutes == nil {
+		st.data.TopicRoutes = make(map[string]string)
+	}
+	if st.data.HeldBursts == nil {
+		st.data.HeldBursts = make(map[string]*heldBurst)
+	}
+	for _, sug := range st.data.Suggestions {
+		// the bot stopped part way through posting it, submitLink refuses it again if it did get posted
+		if sug.Status == suggestionApproving {
+			sug.Status = suggestionPending
+		}
+	}
+	for _, post := range st.data.Posts {
+		st.indexPost(*post)
+	}
+	return st, nil
+}
+
+func (st *stateStore) save() error {
+	/*
+		Write the state to a temporary file and rename it over the old one, so a crash mid-write never leaves a
+		truncated state file behind. Callers must hold st.mu.
+	*/
+	if st.readOnly {
+		return nil
+	}
+	contents, err := json.MarshalIndent(&st.data, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(st.path), filepath.Base(st.path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	if _, err = tmp.Write(contents); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err = tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), st.path)
+}
+
+func (st *stateStore) lookupPost(itemID string) (postedMessage, bool) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	post, ok := st.data.Posts[itemID]
+	if !ok {
+		return postedMessage{}, false
+	}
+	return *post, true
+}
+
+func (st *stateStore) findPostByLink(link string) (postedMessage, bool) {
+	/*
+		Admins refer to articles by the link they can see in the channel, so allow looking posts up that way
+	*/
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	if post, ok := st.data.Posts[link]; ok {
+		return *post, true
+	}
+	for _, post := range st.data.Posts {
+		if post.Link == link {
+			return *post, true
+		}
+	}
+	return postedMessage{}, false
+}
+
+func (st *stateStore) recordPost(post postedMessage) error {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	st.indexPost(post)
+	st.data.Posts[post.ItemID] = &post
+	return st.save()
+}
+
+func (st *stateStore) seedFeeds(feeds map[string]string) error {
+	/*
+		Populate the feed list the first time the bot runs. Only done once, so removing every feed with /feed remove
+		doesn't bring the defaults back on the next restart.
+	*/
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	if st.data.FeedsSeeded {
+		return nil
+	}
+	for url, feedType := range feeds {
+		st.data.Feeds[url] = &feedConfig{URL: url, Type: feedType, AddedAt: time.Now()}
+	}
+	st.data.FeedsSeeded = true
+	return st.save()
+}
+
+func (st *stateStore) listFeeds() []feedConfig {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	feeds := make([]feedConfig, 0, len(st.data.Feeds))
+	for _, feed := range st.data.Feeds {
+		feeds = append(feeds, *feed)
+	}
+	sort.Slice(feeds, func(a, b int) bool { return feeds[a].URL < feeds[b].URL })
+	return feeds
+}
+
+func (st *stateStore) lookupFeed(url string) (feedConfig, bool) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	feed, ok := st.data.Feeds[url]
+	if !ok {
+		return feedConfig{}, false
+	}
+	return *feed, true
+}
+
+func (st *stateStore) saveFeed(feed feedConfig) error {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	st.data.Feeds[feed.URL] = &feed
+	return st.save()
+}
+
+func (st *stateStore) updateFeed(url string, change func(feed *feedConfig)) (feedConfig, bool, error) {
+	/*
+		Change a feed's settings in place while holding the lock, so changes to different fields made at the same
+		time, like a poll recording LastItemAt while an admin sets the burst limit, can't overwrite each other.
+		Returns the feed as changed, and false if it isn't being monitored.
+	*/
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	feed, ok := st.data.Feeds[url]
+	if !ok {
+		return feedConfig{}, false, nil
+	}
+	change(feed)
+	return *feed, true, st.save()
+}
+
+func (st *stateStore) recordFeedItems(url string, at time.Time) error {
+	_, _, err := st.updateFeed(url, func(feed *feedConfig) { feed.LastItemAt = at })
+	return err
+}
+
+func (st *stateStore) removeFeed(url string) error {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	delete(st.data.Feeds, url)
+	return st.save()
+}
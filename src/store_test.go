@@ -0,0 +1,148 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+func TestStorePostsSurviveRestart(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state.json")
+	st, err := loadStateStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	post := postedMessage{ItemID: "item-1", ChannelID: "news", MessageID: "message-1", Title: "A title", Link: "https://example.com/1", PostedAt: time.Now()}
+	if err = st.recordPost(post); err != nil {
+		t.Fatal(err)
+	}
+
+	reloaded, err := loadStateStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, ok := reloaded.lookupPost("item-1"); !ok || got.MessageID != "message-1" {
+		t.Errorf("post not reloaded, got %+v", got)
+	}
+	if got, ok := reloaded.findPostByLink("https://example.com/1"); !ok || got.ItemID != "item-1" {
+		t.Errorf("post not found by its link, got %+v", got)
+	}
+	if results := reloaded.searchArchive("title"); len(results) != 1 {
+		t.Errorf("search index not rebuilt on load, got %d results", len(results))
+	}
+
+	// no temporary files are left next to the state file
+	if matches, _ := filepath.Glob(path + ".*.tmp"); len(matches) != 0 {
+		t.Errorf("temporary files left behind: %v", matches)
+	}
+}
+
+func TestStoreReadOnlyNeverWrites(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state.json")
+	st, err := loadStateStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	st.readOnly = true
+	if err = st.recordPost(postedMessage{ItemID: "item-1"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = os.Stat(path); !os.IsNotExist(err) {
+		t.Errorf("read only store wrote its state file, %v", err)
+	}
+	if _, ok := st.lookupPost("item-1"); !ok {
+		t.Error("read only store should still remember the post in memory")
+	}
+}
+
+func TestItemKeysDistinctWithoutIDs(t *testing.T) {
+	// entries without an ID or a link fall back to their titles, rather than all sharing one key
+	items := []discordMessageData{{Title: "First"}, {Title: "Second"}, {Link: "https://example.com/3", Title: "Third"}}
+	added, updated := diffRssItems(items[:1], items)
+	if len(added) != 2 || len(updated) != 0 {
+		t.Errorf("expected two new items, got %d new and %d updated", len(added), len(updated))
+	}
+}
+
+func TestRetractFailureKeepsPost(t *testing.T) {
+	fake := setupFakeDiscord(t)
+	link := "https://example.com/article"
+	submitNewRssContent([]discordMessageData{{ID: "item-1", Title: "Original", Link: link}})
+
+	fake.failing = true
+	interactionHandler(fake, commandInteraction("retract", testMember("moderator", discordgo.PermissionAdministrator), stringOption("link", link)))
+	if post, _ := store.findPostByLink(link); post.Retracted {
+		t.Error("post marked retracted though its message wasn't deleted")
+	}
+}
+
+func TestUpdateFeedKeepsConcurrentChanges(t *testing.T) {
+	st, err := loadStateStore(filepath.Join(t.TempDir(), "state.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = st.saveFeed(feedConfig{URL: testFeedUrl, Type: "zdi"}); err != nil {
+		t.Fatal(err)
+	}
+
+	// a poll and an admin changing different fields of the same feed at once
+	at := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	var wg sync.WaitGroup
+	for idx := 1; idx <= 20; idx++ {
+		wg.Add(2)
+		go func(limit int) {
+			defer wg.Done()
+			st.updateFeed(testFeedUrl, func(feed *feedConfig) { feed.BurstLimit = limit })
+		}(idx)
+		go func() {
+			defer wg.Done()
+			st.recordFeedItems(testFeedUrl, at)
+		}()
+	}
+	wg.Wait()
+	st.markBackfilled(testFeedUrl)
+	st.updateFeed(testFeedUrl, func(feed *feedConfig) { feed.Paused = true })
+
+	feed, _ := st.lookupFeed(testFeedUrl)
+	if feed.BurstLimit == 0 || !feed.LastItemAt.Equal(at) || !feed.Backfilled || !feed.Paused {
+		t.Errorf("a change was lost, got %+v", feed)
+	}
+	if _, ok, _ := st.updateFeed("https://example.com/unknown", func(*feedConfig) {}); ok {
+		t.Error("updated a feed that isn't monitored")
+	}
+}
+
+func TestNewsPagesKeepTheWholeQuery(t *testing.T) {
+	fake := setupFakeDiscord(t)
+	for idx := 0; idx < newsPageSize+1; idx++ {
+		post := postedMessage{ItemID: fmt.Sprintf("item-%d", idx), ChannelID: "news", MessageID: fmt.Sprintf("message-%d", idx), Title: "A title", Link: fmt.Sprintf("https://example.com/%d", idx), PostedAt: time.Now()}
+		if err := store.recordPost(post); err != nil {
+			t.Fatal(err)
+		}
+	}
+	member := testMember("member", 0)
+
+	// the longest query allowed makes it into the next page's button whole
+	query := strings.TrimSpace(strings.Repeat("title ", maxNewsQueryLength/6))
+	interactionHandler(fake, commandInteraction("news", member, subcommandOption("search", stringOption("query", query))))
+	next := fake.responses[len(fake.responses)-1].Data.Components[0].(discordgo.ActionsRow).Components[1].(discordgo.Button)
+	if len(next.CustomID) > 100 || !strings.HasSuffix(next.CustomID, customIdSep+query) {
+		t.Fatalf("next button lost the query, custom ID %q", next.CustomID)
+	}
+	interactionHandler(fake, componentInteraction(next.CustomID, member))
+	if page := fake.responses[len(fake.responses)-1].Data; len(page.Embeds) != 1 || page.Embeds[0].Footer.Text != "Page 2 of 2" {
+		t.Errorf("expected the second page of the same results, got %+v", page)
+	}
+
+	// and anything longer is refused rather than cut short
+	interactionHandler(fake, commandInteraction("news", member, subcommandOption("search", stringOption("query", query+" title"))))
+	if response := fake.lastResponse(t); !strings.HasPrefix(response, "Searches are limited") {
+		t.Errorf("unexpected response %q", response)
+	}
+}
@@ -0,0 +1,329 @@
+/*
+Community submissions. Any member can /suggest a link, which lands in a moderation queue in the admin channel where the
+committee can approve, reject or edit it with buttons.
+*/
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+const (
+	suggestionPending = "pending"
+	// claimed by a moderator and being posted, so nobody else can approve it at the same time
+	suggestionApproving = "approving"
+	suggestionApproved  = "approved"
+	suggestionRejected  = "rejected"
+)
+
+// suggestion is a link submitted by a member, waiting for or having had a decision from the committee
+type suggestion struct {
+	ID             string    `json:"id"`
+	Link           string    `json:"link"`
+	Title          string    `json:"title"`
+	Description    string    `json:"description"`
+	SubmitterID    string    `json:"submitter_id"`
+	SubmitterName  string    `json:"submitter_name"`
+	AdminMessageID string    `json:"admin_message_id"`
+	Status         string    `json:"status"`
+	ReviewedBy     string    `json:"reviewed_by,omitempty"`
+	Reason         string    `json:"reason,omitempty"`
+	SubmittedAt    time.Time `json:"submitted_at"`
+}
+
+var suggestCommand = &discordgo.ApplicationCommand{
+	Name:        "suggest",
+	Description: "Suggest an article for the news channel",
+
+	Options: []*discordgo.ApplicationCommandOption{
+		{
+			Type:        discordgo.ApplicationCommandOptionString,
+			Name:        "link",
+			Description: "The link to suggest",
+			Required:    true,
+		},
+		{
+			Type:        discordgo.ApplicationCommandOptionString,
+			Name:        "title",
+			Description: "The title of the article",
+			Required:    false,
+		},
+		{
+			Type:        discordgo.ApplicationCommandOptionString,
+			Name:        "description",
+			Description: "Why the article is interesting",
+			Required:    false,
+		},
+	},
+}
+
+func (st *stateStore) saveSuggestion(sug suggestion) error {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	st.data.Suggestions[sug.ID] = &sug
+	return st.save()
+}
+
+func (st *stateStore) claimSuggestion(id string, from string, to string) (suggestion, bool) {
+	/*
+		Move a suggestion from one status to another, only if it's still in the first. Whoever moves it out of
+		pending is the one moderator who gets to deal with it.
+	*/
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	sug, ok := st.data.Suggestions[id]
+	if !ok || sug.Status != from {
+		return suggestion{}, false
+	}
+	sug.Status = to
+	if err := st.save(); err != nil {
+		slog.Error("saving state", "err", err)
+	}
+	return *sug, true
+}
+
+func (st *stateStore) lookupSuggestion(id string) (suggestion, bool) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	sug, ok := st.data.Suggestions[id]
+	if !ok {
+		return suggestion{}, false
+	}
+	return *sug, true
+}
+
+func suggestCommandHandler(s discordClient, i *discordgo.InteractionCreate) {
+	/*
+		Open to every member. The suggestion is saved and posted to the admin channel, and nothing reaches the news
+		channel until someone on the committee approves it.
+	*/
+	if i.Member == nil {
+		interactionRespondEphemeral(s, i, "Suggestions can only be made from inside the server")
+		return
+	}
+
+	optionMap := interactionOptions(i)
+	link, err := validateSubmissionLink(optionMap["link"].StringValue())
+	if err != nil {
+		interactionRespondEphemeral(s, i, err.Error())
+		return
+	}
+	if post, ok := store.findPostByLink(link); ok && !post.Retracted {
+		interactionRespondEphemeral(s, i, "Thanks, but that article has already been posted")
+		return
+	}
+
+	sug := suggestion{
+		ID:            i.ID,
+		Link:          link,
+		SubmitterID:   i.Member.User.ID,
+		SubmitterName: i.Member.User.Username,
+		Status:        suggestionPending,
+		SubmittedAt:   time.Now(),
+	}
+	if opt, ok := optionMap["title"]; ok {
+		sug.Title = opt.StringValue()
+	}
+	if opt, ok := optionMap["description"]; ok {
+		sug.Description = opt.StringValue()
+	}
+
+	message, err := s.sendMessage(adminChannelId, &discordgo.MessageSend{
+		Embeds:     []*discordgo.MessageEmbed{sug.embed()},
+		Components: sug.components(),
+	})
+	if err != nil {
+		slog.Error("posting suggestion to admin channel", "link", link, "err", err)
+		interactionRespondEphemeral(s, i, "Sorry, your suggestion couldn't be sent to the committee")
+		return
+	}
+
+	sug.AdminMessageID = message.ID
+	if err = store.saveSuggestion(sug); err != nil {
+		slog.Error("saving state", "err", err)
+	}
+	auditInteraction(i, "queued for review", message.ID)
+	interactionRespondEphemeral(s, i, "Thanks! Your suggestion has been sent to the committee for review")
+}
+
+func (sug suggestion) embed() *discordgo.MessageEmbed {
+	title := sug.Title
+	if title == "" {
+		title = "(no title)"
+	}
+
+	embed := &discordgo.MessageEmbed{
+		Type:        discordgo.EmbedTypeRich,
+		Title:       "Suggested article: " + title,
+		Description: sug.Description,
+		Fields: []*discordgo.MessageEmbedField{
+			{Name: "Link", Value: sug.Link},
+			{Name: "Suggested by", Value: fmt.Sprintf("<@%s>", sug.SubmitterID), Inline: true},
+			{Name: "Status", Value: sug.Status, Inline: true},
+		},
+	}
+	if sug.ReviewedBy != "" {
+		embed.Fields = append(embed.Fields, &discordgo.MessageEmbedField{Name: "Reviewed by", Value: sug.ReviewedBy, Inline: true})
+	}
+	if sug.Reason != "" {
+		embed.Fields = append(embed.Fields, &discordgo.MessageEmbedField{Name: "Reason", Value: sug.Reason})
+	}
+	return embed
+}
+
+func (sug suggestion) components() []discordgo.MessageComponent {
+	/*
+		The moderation buttons, only shown while the suggestion is waiting for a decision
+	*/
+	if sug.Status != suggestionPending {
+		return []discordgo.MessageComponent{}
+	}
+
+	customId := func(action string) string {
+		return strings.Join([]string{"suggest", action, sug.ID}, customIdSep)
+	}
+	return []discordgo.MessageComponent{
+		discordgo.ActionsRow{
+			Components: []discordgo.MessageComponent{
+				discordgo.Button{Label: "Approve", Style: discordgo.SuccessButton, CustomID: customId("approve")},
+				discordgo.Button{Label: "Reject", Style: discordgo.DangerButton, CustomID: customId("reject")},
+				discordgo.Button{Label: "Edit", Style: discordgo.SecondaryButton, CustomID: customId("edit")},
+			},
+		},
+	}
+}
+
+func suggestComponentHandler(s discordClient, i *discordgo.InteractionCreate) {
+	/*
+		Handles both the moderation buttons and the modals they open. Rejecting and editing ask for more input in a
+		modal first, and the decision is made when the modal is submitted.
+	*/
+	var customId string
+	if i.Type == discordgo.InteractionModalSubmit {
+		customId = i.ModalSubmitData().CustomID
+	} else {
+		customId = i.MessageComponentData().CustomID
+	}
+
+	parts := strings.Split(customId, customIdSep)
+	if len(parts) != 3 {
+		return
+	}
+	if !checkInteractionCapability(s, i, capModerate) {
+		return
+	}
+
+	sug, ok := store.lookupSuggestion(parts[2])
+	if !ok || sug.Status != suggestionPending {
+		interactionRespondEphemeral(s, i, "This suggestion has already been dealt with")
+		return
+	}
+
+	switch parts[1] {
+	case "approve":
+		approveSuggestion(s, i, sug)
+	case "reject":
+		interactionRespondModal(s, i, strings.Join([]string{"suggest", "rejectreason", sug.ID}, customIdSep), "Reject suggestion",
+			discordgo.TextInput{CustomID: "reason", Label: "Reason, sent to the member", Style: discordgo.TextInputParagraph, Required: true, MaxLength: 1000})
+	case "rejectreason":
+		sug.Reason = modalValues(i)["reason"]
+		rejectSuggestion(s, i, sug)
+	case "edit":
+		interactionRespondModal(s, i, strings.Join([]string{"suggest", "editsubmit", sug.ID}, customIdSep), "Edit suggestion",
+			discordgo.TextInput{CustomID: "title", Label: "Title", Style: discordgo.TextInputShort, Value: sug.Title, MaxLength: 200},
+			discordgo.TextInput{CustomID: "description", Label: "Description", Style: discordgo.TextInputParagraph, Value: sug.Description, MaxLength: 2000})
+	case "editsubmit":
+		values := modalValues(i)
+		sug.Title = values["title"]
+		sug.Description = values["description"]
+		if err := store.saveSuggestion(sug); err != nil {
+			slog.Error("saving state", "err", err)
+		}
+		auditInteraction(i, "edited", sug.AdminMessageID)
+		updateSuggestionMessage(s, i, sug)
+	}
+}
+
+func approveSuggestion(s discordClient, i *discordgo.InteractionCreate, sug suggestion) {
+	/*
+		Publish the suggestion to the news channel, crediting the member who found it
+	*/
+	title := "Member submitted article"
+	if sug.Title != "" {
+		title += ": " + sug.Title
+	}
+	description := strings.TrimSpace(sug.Description + fmt.Sprintf("\n\nSuggested by <@%s>", sug.SubmitterID))
+
+	// claimed before posting so two moderators approving at once can't both post it, and only marked approved
+	// once it's out, so a suggestion that fails to post goes back to pending and can be approved again
+	if _, ok := store.claimSuggestion(sug.ID, suggestionPending, suggestionApproving); !ok {
+		interactionRespondEphemeral(s, i, "This suggestion has already been dealt with")
+		return
+	}
+	messageIDs, err := submitLink(discordMessageData{ID: sug.Link, Title: title, Description: description, Link: sug.Link, Source: "member"})
+	if err != nil {
+		store.claimSuggestion(sug.ID, suggestionApproving, suggestionPending)
+		slog.Error("posting suggestion", "suggestion", sug.ID, "link", sug.Link, "err", err)
+		auditInteraction(i, err.Error())
+		interactionRespondEphemeral(s, i, fmt.Sprintf("Couldn't post the suggestion, it's still pending: %v", err))
+		return
+	}
+
+	sug.Status = suggestionApproved
+	sug.ReviewedBy = i.Member.User.Username
+	if err := store.saveSuggestion(sug); err != nil {
+		slog.Error("saving state", "err", err)
+	}
+	updateSuggestionMessage(s, i, sug)
+	auditInteraction(i, "approved", messageIDs...)
+	notifySubmitter(s, sug, fmt.Sprintf("Your suggestion %s has been approved and posted in <#%s>. Thanks!", sug.Link, newsChannelId))
+}
+
+func rejectSuggestion(s discordClient, i *discordgo.InteractionCreate, sug suggestion) {
+	if _, ok := store.claimSuggestion(sug.ID, suggestionPending, suggestionRejected); !ok {
+		interactionRespondEphemeral(s, i, "This suggestion has already been dealt with")
+		return
+	}
+	sug.Status = suggestionRejected
+	sug.ReviewedBy = i.Member.User.Username
+	if err := store.saveSuggestion(sug); err != nil {
+		slog.Error("saving state", "err", err)
+	}
+	updateSuggestionMessage(s, i, sug)
+	auditInteraction(i, "rejected", sug.AdminMessageID)
+
+	notifySubmitter(s, sug, fmt.Sprintf("Your suggestion %s wasn't accepted for the news channel. Reason: %s", sug.Link, sug.Reason))
+}
+
+func updateSuggestionMessage(s discordClient, i *discordgo.InteractionCreate, sug suggestion) {
+	/*
+		Redraw the suggestion in the admin channel in response to the button or modal that changed it
+	*/
+	if err := s.respond(i.Interaction, &discordgo.InteractionResponse{
+		Type: discordgo.InteractionResponseUpdateMessage,
+		Data: &discordgo.InteractionResponseData{
+			Embeds:     []*discordgo.MessageEmbed{sug.embed()},
+			Components: sug.components(),
+		},
+	}); err != nil {
+		slog.Warn("interaction response failed", "err", err)
+	}
+}
+
+func notifySubmitter(s discordClient, sug suggestion, content string) {
+	/*
+		Direct message the member who made the suggestion. Members can block DMs from server bots, so failing is
+		only logged.
+	*/
+	if err := s.sendDirectMessage(sug.SubmitterID, content); err != nil {
+		slog.Warn("sending DM", "user", sug.SubmitterName, "err", err)
+	}
+}
@@ -0,0 +1,205 @@
+/*
+Topic tags for every item, whatever feed or person it came from. Tags come from the outlet's own categories where it
+gives any, and from keyword rules over the title and summary otherwise. They're shown on the posted embed, can limit a
+feed to the topics the server cares about, route a topic to its own channel, and are searchable with /news.
+*/
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+// topicRule tags an item with Tag if the outlet filed it under one of Categories, or its text matches Keywords
+type topicRule struct {
+	Tag        string
+	Categories []string
+	Keywords   *regexp.Regexp
+}
+
+func keywords(pattern string) *regexp.Regexp {
+	return regexp.MustCompile(`(?i)\b(?:` + pattern + `)`)
+}
+
+// the rules in the order tags are shown
+var topicRules = []topicRule{
+	{"zero-day", []string{"Zero-Day"}, keywords(`zero[- ]?days?\b|0[- ]?days?\b|actively exploited|exploited in the wild`)},
+	{"vulnerability", []string{"Vulnerability", "AppSec"}, keywords(`vulnerabilit|CVE-\d{4}-\d+|flaws?\b|security (?:update|patch|advisory)|patch(?:es|ed)?\b|exploit`)},
+	{"ransomware", []string{"Ransomware"}, keywords(`ransomware|lockbit|blackcat|alphv|cl0p|extortion`)},
+	{"malware", []string{"Malware"}, keywords(`malware|trojan|botnet|info-?stealer|stealer\b|backdoor|spyware|wiper\b|loader\b`)},
+	{"apt", []string{"APT", "Espionage", "Cyber Espionage", "National Security"}, keywords(`APT ?\d+\b|advanced persistent threat|state[- ]sponsored|nation[- ]state|espionage|lazarus|volt typhoon|sandworm|(?:fancy|cozy) bear`)},
+	{"phishing", nil, keywords(`phishing|smishing|vishing|business email compromise|BEC\b|credential harvesting`)},
+	{"web", []string{"Web Security"}, keywords(`XSS\b|cross[- ]site|SQL injection|SQLi\b|SSRF\b|CSRF\b|request smuggling|web ?(?:app|application|server|cache)s?\b|wordpress|deserializ|prototype pollution|OAuth\b`)},
+	{"browser", nil, keywords(`browsers?\b|chrome\b|chromium|firefox|safari\b|webkit|V8\b`)},
+	{"mobile", []string{"Mobile Security"}, keywords(`android|iOS\b|iPhone|iPad|mobile|APK\b`)},
+	{"cloud", []string{"Cloud Security"}, keywords(`cloud\b|AWS\b|azure|GCP\b|google cloud|kubernetes|K8s\b|S3 buckets?\b|SaaS\b`)},
+	{"kernel", nil, keywords(`kernel|privilege escalation|LPE\b|drivers?\b|syscalls?\b|hypervisor|KASLR\b`)},
+	{"supply-chain", nil, keywords(`supply[- ]chain|npm\b|PyPI\b|rubygems|typosquat|dependency confusion|malicious packages?\b`)},
+	{"ics", nil, keywords(`ICS\b|SCADA\b|PLCs?\b|industrial control|operational technology`)},
+}
+
+func topicTags() []string {
+	tags := make([]string, 0, len(topicRules))
+	for _, rule := range topicRules {
+		tags = append(tags, rule.Tag)
+	}
+	return tags
+}
+
+func topicChoices() []*discordgo.ApplicationCommandOptionChoice {
+	choices := make([]*discordgo.ApplicationCommandOptionChoice, 0, len(topicRules))
+	for _, tag := range topicTags() {
+		choices = append(choices, &discordgo.ApplicationCommandOptionChoice{Name: tag, Value: tag})
+	}
+	return choices
+}
+
+func classifyItem(item discordMessageData) []string {
+	/*
+		The topic tags of an item. An outlet's category is taken at its word, the keywords only see the title and
+		summary, so an article is tagged for what it's about rather than everything it mentions in passing.
+	*/
+	text := item.Title + "\n" + item.Description
+	var tags []string
+	for _, rule := range topicRules {
+		if rule.Keywords.MatchString(text) || hasCategory(item.Categories, rule.Categories) {
+			tags = append(tags, rule.Tag)
+		}
+	}
+	return tags
+}
+
+func hasCategory(categories []string, wanted []string) bool {
+	for _, category := range categories {
+		for _, want := range wanted {
+			if strings.EqualFold(strings.TrimSpace(category), want) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func parseTopics(text string) ([]string, error) {
+	/*
+		Read a comma or space separated list of topic tags, as given to /feed topics
+	*/
+	known := make(map[string]bool)
+	for _, tag := range topicTags() {
+		known[tag] = true
+	}
+	var topics []string
+	for _, topic := range strings.FieldsFunc(strings.ToLower(text), func(r rune) bool { return r == ',' || r == ' ' }) {
+		if !known[topic] {
+			return nil, fmt.Errorf("'%s' isn't a topic, the topics are %s", topic, strings.Join(topicTags(), ", "))
+		}
+		topics = append(topics, topic)
+	}
+	return topics, nil
+}
+
+func (feed feedConfig) wantsTopics(tags []string) bool {
+	if len(feed.Topics) == 0 {
+		return true
+	}
+	for _, tag := range tags {
+		for _, topic := range feed.Topics {
+			if tag == topic {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func filterTopics(feedUrl string, items []discordMessageData) []discordMessageData {
+	/*
+		Tag a poll's items, and drop new ones outside the feed's topics if it's limited to some. Updates always go
+		through, so an article that's already been posted can still be edited.
+	*/
+	feed, _ := store.lookupFeed(feedUrl)
+	var kept []discordMessageData
+	for _, item := range items {
+		item.Tags = classifyItem(item)
+		if !item.Updated && !feed.wantsTopics(item.Tags) {
+			continue
+		}
+		kept = append(kept, item)
+	}
+	return kept
+}
+
+func (st *stateStore) setTopicRoute(topic string, channelID string) error {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	if channelID == "" {
+		delete(st.data.TopicRoutes, topic)
+	} else {
+		st.data.TopicRoutes[topic] = channelID
+	}
+	return st.save()
+}
+
+func (st *stateStore) topicRoutes() map[string]string {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	routes := make(map[string]string, len(st.data.TopicRoutes))
+	for topic, channelID := range st.data.TopicRoutes {
+		routes[topic] = channelID
+	}
+	return routes
+}
+
+func topicChannel(tags []string) string {
+	/*
+		The channel an item with these tags is posted to. The first of its tags with a route wins, in the order of
+		topicRules, and anything else goes to the news channel.
+	*/
+	routes := store.topicRoutes()
+	for _, tag := range tags {
+		if channelID, ok := routes[tag]; ok {
+			return channelID
+		}
+	}
+	return newsChannelId
+}
+
+func (st *stateStore) topicArchive(topic string) []postedMessage {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	var results []postedMessage
+	for _, post := range st.data.Posts {
+		if post.Retracted {
+			continue
+		}
+		for _, tag := range post.Tags {
+			if tag == topic {
+				results = append(results, *post)
+				break
+			}
+		}
+	}
+	sortPostsNewestFirst(results)
+	return results
+}
+
+func describeRoutes(routes map[string]string) string {
+	topics := make([]string, 0, len(routes))
+	for topic := range routes {
+		topics = append(topics, topic)
+	}
+	sort.Strings(topics)
+
+	var lines []string
+	for _, topic := range topics {
+		lines = append(lines, fmt.Sprintf("`%s` → <#%s>", topic, routes[topic]))
+	}
+	return strings.Join(lines, "\n")
+}
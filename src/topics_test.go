@@ -0,0 +1,92 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+func TestClassifyItem(t *testing.T) {
+	cases := []struct {
+		item discordMessageData
+		want string
+	}{
+		{discordMessageData{Title: "Apple Patches 3 Actively Exploited WebKit Zero-Days"}, "zero-day,vulnerability,browser"},
+		{discordMessageData{Title: "LockBit affiliate arrested", Description: "The gang's leak site went dark on Monday."}, "ransomware"},
+		{discordMessageData{Title: "Lazarus Group drops new backdoor on defence contractors"}, "malware,apt"},
+		{discordMessageData{Title: "Malicious npm packages steal developer credentials", Description: "An info-stealer hidden in a postinstall script."}, "malware,supply-chain"},
+		{discordMessageData{Title: "Misconfigured Android apps leak AWS keys"}, "mobile,cloud"},
+		{discordMessageData{Title: "Exploiting a use-after-free for Linux privilege escalation"}, "vulnerability,kernel"},
+		{discordMessageData{Title: "Bending the rules of web cache deception"}, "web"},
+		{discordMessageData{Title: "Weekly roundup", Categories: []string{"Cyber Espionage", "mobile security"}}, "apt,mobile"},
+		{discordMessageData{Title: "Company announces new CEO"}, ""},
+	}
+	for _, c := range cases {
+		if got := strings.Join(classifyItem(c.item), ","); got != c.want {
+			t.Errorf("classifyItem(%q) = %q, want %q", c.item.Title, got, c.want)
+		}
+	}
+}
+
+func TestParseTopics(t *testing.T) {
+	topics, err := parseTopics("Ransomware, apt web")
+	if err != nil || strings.Join(topics, ",") != "ransomware,apt,web" {
+		t.Errorf("got %v, %v", topics, err)
+	}
+	if _, err = parseTopics("ransomware, gossip"); err == nil || !strings.Contains(err.Error(), "gossip") {
+		t.Errorf("expected an unknown topic to be refused, got %v", err)
+	}
+}
+
+func TestFilterTopics(t *testing.T) {
+	setupFakeDiscord(t)
+	if err := store.saveFeed(feedConfig{URL: testFeedUrl, Type: "zdi", Topics: []string{"ransomware"}}); err != nil {
+		t.Fatal(err)
+	}
+
+	items := filterTopics(testFeedUrl, []discordMessageData{
+		{ID: "ransomware", Title: "New ransomware strain"},
+		{ID: "other", Title: "Company announces new CEO"},
+		{ID: "edited", Title: "Company announces new CFO", Updated: true},
+	})
+	if len(items) != 2 || items[0].ID != "ransomware" || items[1].ID != "edited" {
+		t.Fatalf("expected the ransomware item and the update, got %+v", items)
+	}
+	if strings.Join(items[0].Tags, ",") != "ransomware" {
+		t.Errorf("kept item wasn't tagged, got %v", items[0].Tags)
+	}
+}
+
+func TestTopicsShownRoutedAndSearchable(t *testing.T) {
+	fake := setupFakeDiscord(t)
+	admin := testMember("admin", discordgo.PermissionAdministrator)
+	route := &discordgo.ApplicationCommandInteractionDataOption{Name: "channel", Type: discordgo.ApplicationCommandOptionChannel, Value: "ransomware-channel"}
+	interactionHandler(fake, commandInteraction("feed", admin, subcommandOption("route", stringOption("topic", "ransomware"), route)))
+
+	submitNewRssContent([]discordMessageData{
+		{ID: "1", Title: "New ransomware strain", Link: "https://example.com/1"},
+		{ID: "2", Title: "Browser bug bounty doubled", Link: "https://example.com/2"},
+	})
+	routed, news := fake.sentTo("ransomware-channel"), fake.sentTo(testNewsChannel)
+	if len(routed) != 1 || len(news) != 1 {
+		t.Fatalf("expected one article routed and one in the news channel, got %d and %d", len(routed), len(news))
+	}
+	fields := news[0].Message.Embeds[0].Fields
+	if topics := fields[len(fields)-1]; topics.Name != "Topics" || topics.Value != "browser" {
+		t.Errorf("expected the topics as an embed field, got %+v", topics)
+	}
+
+	if results := store.topicArchive("ransomware"); len(results) != 1 || results[0].ItemID != "1" || results[0].ChannelID != "ransomware-channel" {
+		t.Errorf("expected the routed post under its topic, got %+v", results)
+	}
+	if results := store.searchArchive("browser"); len(results) != 1 {
+		t.Errorf("expected to find the post by its topic, got %d results", len(results))
+	}
+
+	// and the route can be taken away again
+	interactionHandler(fake, commandInteraction("feed", admin, subcommandOption("route", stringOption("topic", "ransomware"))))
+	if channel := topicChannel([]string{"ransomware"}); channel != testNewsChannel {
+		t.Errorf("expected ransomware back in the news channel, got %q", channel)
+	}
+}
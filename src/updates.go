@@ -0,0 +1,109 @@
+/*
+What happens when an outlet changes an article the bot has already posted. Each feed has an update policy: ignore the
+change, edit the earlier message in place (the default), or edit it and also post an "Updated:" notice replying to it.
+Only substantive changes, to the title, summary or full text, are acted on; an outlet just bumping an entry's updated
+time leaves the message alone.
+*/
+package main
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"log/slog"
+	"strings"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+const (
+	updateEdit   = "edit"
+	updateIgnore = "ignore"
+	updateNotice = "notice"
+)
+
+func updatePolicyChoices() []*discordgo.ApplicationCommandOptionChoice {
+	return []*discordgo.ApplicationCommandOptionChoice{
+		{Name: "edit the earlier message", Value: updateEdit},
+		{Name: "edit it and post an update notice", Value: updateNotice},
+		{Name: "ignore changes", Value: updateIgnore},
+	}
+}
+
+func (feed feedConfig) updatePolicy() string {
+	if feed.UpdatePolicy != "" {
+		return feed.UpdatePolicy
+	}
+	return updateEdit
+}
+
+func hashContent(text string) string {
+	/*
+		Hash an article's full text, so a change to it can be spotted without keeping the text itself. Whitespace is
+		normalised as outlets reflow their markup without changing a word.
+	*/
+	if text = strings.Join(strings.Fields(text), " "); text == "" {
+		return ""
+	}
+	return fmt.Sprintf("%x", sha256.Sum256([]byte(text)))
+}
+
+func (item discordMessageData) changes(title string, description string, contentHash string) []string {
+	/*
+		What's substantively different about item from an earlier version of it. The full text is only compared when
+		both versions have a hash of it, as posts from before hashes were kept don't.
+	*/
+	var changed []string
+	if item.Title != title {
+		changed = append(changed, "title")
+	}
+	if item.Description != description {
+		changed = append(changed, "summary")
+	}
+	if item.ContentHash != "" && contentHash != "" && item.ContentHash != contentHash {
+		changed = append(changed, "text")
+	}
+	return changed
+}
+
+func applyUpdatePolicy(feedUrl string, items []discordMessageData) []discordMessageData {
+	/*
+		Drop or mark a poll's updated items according to the feed's policy. New items are untouched.
+	*/
+	policy := updateEdit
+	if feed, ok := store.lookupFeed(feedUrl); ok {
+		policy = feed.updatePolicy()
+	}
+	if policy == updateEdit {
+		return items
+	}
+
+	var kept []discordMessageData
+	for _, item := range items {
+		if item.Updated {
+			if policy == updateIgnore {
+				slog.Debug("ignoring updated item", "feed", feedUrl, "item", item.itemKey())
+				continue
+			}
+			item.Notice = true
+		}
+		kept = append(kept, item)
+	}
+	return kept
+}
+
+func postUpdateNotice(post postedMessage, item discordMessageData, changed []string) *discordgo.Message {
+	/*
+		Reply to the earlier message saying the article has changed, for feeds whose readers want to hear about it
+	*/
+	notice, err := discord.sendMessage(post.ChannelID, &discordgo.MessageSend{
+		Content:   fmt.Sprintf("Updated: **%s** (%s changed)", truncate(item.Title, 200), strings.Join(changed, ", ")),
+		Reference: &discordgo.MessageReference{ChannelID: post.ChannelID, MessageID: post.MessageID},
+	})
+	if err != nil {
+		slog.Error("posting update notice", "message", post.MessageID, "err", err)
+		metricDiscordSends.inc("failure")
+		return nil
+	}
+	metricDiscordSends.inc("success")
+	return notice
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+// postOriginal posts the first version of an article to a feed with the given update policy
+func postOriginal(t *testing.T, policy string) discordMessageData {
+	t.Helper()
+	if err := store.saveFeed(feedConfig{URL: testFeedUrl, Type: "projectzero", UpdatePolicy: policy}); err != nil {
+		t.Fatal(err)
+	}
+	item := discordMessageData{
+		ID:          "post-1",
+		Title:       "A title",
+		Description: "A summary",
+		Link:        "https://example.com/1",
+		Modified:    time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC),
+		ContentHash: hashContent("The full text"),
+	}
+	submitNewRssContent([]discordMessageData{item})
+	return item
+}
+
+func TestUpdateEditsSilently(t *testing.T) {
+	fake := setupFakeDiscord(t)
+	item := postOriginal(t, "")
+
+	item.Title, item.Updated = "A new title", true
+	submitNewRssContent(applyUpdatePolicy(testFeedUrl, []discordMessageData{item}))
+	if len(fake.edits) != 1 || fake.edits[0].Embed.Title != "A new title" {
+		t.Errorf("expected the earlier message to be edited, got %+v", fake.edits)
+	}
+	if sent := fake.sentTo(testNewsChannel); len(sent) != 1 {
+		t.Errorf("nothing should be posted for an edit, got %d messages", len(sent))
+	}
+}
+
+func TestUpdateNotice(t *testing.T) {
+	fake := setupFakeDiscord(t)
+	item := postOriginal(t, updateNotice)
+	original := fake.sentTo(testNewsChannel)[0]
+
+	item.ContentHash, item.Updated = hashContent("The full text, corrected"), true
+	submitNewRssContent(applyUpdatePolicy(testFeedUrl, []discordMessageData{item}))
+	sent := fake.sentTo(testNewsChannel)
+	if len(fake.edits) != 1 || len(sent) != 2 {
+		t.Fatalf("expected an edit and a notice, got %d edits and %d messages", len(fake.edits), len(sent))
+	}
+	notice := sent[1].Message
+	if notice.Reference == nil || notice.Reference.MessageID != original.ID || notice.Content != "Updated: **A title** (text changed)" {
+		t.Errorf("unexpected notice %q replying to %+v", notice.Content, notice.Reference)
+	}
+	if post, _ := store.lookupPost(item.itemKey()); post.ContentHash != item.ContentHash {
+		t.Error("new content hash not recorded")
+	}
+}
+
+func TestUpdateIgnored(t *testing.T) {
+	fake := setupFakeDiscord(t)
+	item := postOriginal(t, updateIgnore)
+
+	item.Title, item.Updated = "A new title", true
+	added := discordMessageData{ID: "post-2", Title: "Another", Link: "https://example.com/2"}
+	items := applyUpdatePolicy(testFeedUrl, []discordMessageData{item, added})
+	if len(items) != 1 || items[0].ID != "post-2" {
+		t.Errorf("expected only the new item to be kept, got %+v", items)
+	}
+	submitNewRssContent(items)
+	if len(fake.edits) != 0 {
+		t.Errorf("ignored update edited the message, %+v", fake.edits)
+	}
+}
+
+func TestUpdateTimestampOnly(t *testing.T) {
+	fake := setupFakeDiscord(t)
+	item := postOriginal(t, updateNotice)
+
+	bumped := item
+	bumped.Modified = item.Modified.Add(time.Hour)
+	_, updated := diffRssItems([]discordMessageData{item}, []discordMessageData{bumped})
+	if len(updated) != 1 {
+		t.Fatalf("expected a later updated time to be seen as an update, got %+v", updated)
+	}
+	submitNewRssContent(applyUpdatePolicy(testFeedUrl, updated))
+	if len(fake.edits) != 0 || len(fake.sentTo(testNewsChannel)) != 1 {
+		t.Errorf("a bumped updated time alone shouldn't touch the message, got %d edits", len(fake.edits))
+	}
+}
+
+func TestUpdateOlderVersionSkipped(t *testing.T) {
+	fake := setupFakeDiscord(t)
+	item := postOriginal(t, "")
+
+	item.Title, item.Updated = "An older title", true
+	item.Modified = item.Modified.Add(-time.Hour)
+	submitNewRssContent([]discordMessageData{item})
+	if len(fake.edits) != 0 {
+		t.Errorf("an older version of the article replaced the posted one, %+v", fake.edits)
+	}
+}
+
+func TestHashContent(t *testing.T) {
+	if hashContent("some  text\n") != hashContent("some text") {
+		t.Error("reflowed text should hash the same")
+	}
+	if hashContent("some text") == hashContent("other text") {
+		t.Error("different text hashed the same")
+	}
+	if hashContent(" \n") != "" {
+		t.Error("empty text should have no hash")
+	}
+}
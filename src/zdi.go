@@ -5,8 +5,9 @@ Code for handling the RSS feed provided by Zero Day Initiative
 package main
 
 import (
+	"context"
 	"errors"
-	"log"
+	"log/slog"
 )
 
 type ZDIRssFeed struct {
@@ -29,11 +30,28 @@ type ZDIItem struct {
 	GUID        string `xml:"guid"`
 }
 
-func (zdi *ZDIRssFeed) ParseNewRssContent(oldData RSSFeed, newData RSSFeed) ([]discordMessageData, error) {
+func (item ZDIItem) messageData() discordMessageData {
+	return discordMessageData{
+		ID:          item.GUID,
+		Title:       item.Title,
+		Description: item.Description,
+		Link:        item.Link,
+		Published:   parseFeedDate(item.PubDate),
+	}
+}
+
+func (zdi *ZDIRssFeed) messageData() []discordMessageData {
+	items := make([]discordMessageData, 0, len(zdi.Channel.Items))
+	for _, item := range zdi.Channel.Items {
+		items = append(items, item.messageData())
+	}
+	return items
+}
+
+func (zdi *ZDIRssFeed) ParseNewRssContent(ctx context.Context, oldData RSSFeed, newData RSSFeed) ([]discordMessageData, error) {
 	var (
 		oldZdiData *ZDIRssFeed
 		newZdiData *ZDIRssFeed
-		newContent []discordMessageData
 
 		ok bool
 	)
@@ -44,29 +62,11 @@ func (zdi *ZDIRssFeed) ParseNewRssContent(oldData RSSFeed, newData RSSFeed) ([]d
 		return nil, errors.New("newData is not of type ZDIRssFeed")
 	}
 
-	for _, newFeedItem := range newZdiData.Channel.Items {
-		itemExists := false
-		for _, oldFeedItem := range oldZdiData.Channel.Items {
-			if oldFeedItem.GUID == newFeedItem.GUID {
-				// log.Printf("Article titled '%v' already exists in old data. Stopping iteration.\n", newFeedItem.Title)
-				itemExists = true
-				break
-			}
-		}
-		if !itemExists {
-			log.Printf("Article '%v' is new", newFeedItem.Title)
-			log.Println(newFeedItem)
-
-			messageContent := discordMessageData{
-				Title:       newFeedItem.Title,
-				Description: newFeedItem.Description,
-				Link:        newFeedItem.Link,
-			}
-			newContent = append(newContent, messageContent)
-		}
-	}
-	if len(newContent) > 20 {
-		return nil, errors.New("err: more than 20 new items. Logic bug likely. Exiting")
+	newContent, updatedContent := diffRssItems(oldZdiData.messageData(), newZdiData.messageData())
+	for _, item := range newContent {
+		slog.Debug("new article", "title", item.Title, "item", item.itemKey())
 	}
+
+	newContent = append(newContent, updatedContent...)
 	return newContent, nil
 }